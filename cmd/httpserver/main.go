@@ -1,28 +1,41 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"flag"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"syscall"
 	server "tcpTohttp/internal"
 	"tcpTohttp/internal/headers"
+	"tcpTohttp/internal/middleware"
 	"tcpTohttp/internal/request"
 	"tcpTohttp/internal/response"
+	"tcpTohttp/internal/router"
+	"tcpTohttp/internal/websocket"
+	"time"
 )
 
 const PORT = 42069
-
-func getResponseBody(requestUrl string) (io.ReadCloser, error) {
-	url := strings.TrimPrefix(requestUrl, "/httpbin/")
-	res, err := http.Get("https://httpbin.org/" + url)
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+const CERT_WATCH_INTERVAL = 5 * time.Second
+const SLOW_REQUEST = time.Second
+
+// getResponseBody fetches path from httpbin. path is decoded, so it is
+// escaped again, or a "?" in it would start the query.
+func getResponseBody(path, query string) (io.ReadCloser, error) {
+	target := "https://httpbin.org/" + (&url.URL{Path: path}).EscapedPath()
+	if query != "" {
+		target += "?" + query
+	}
+	res, err := http.Get(target)
 
 	if err != nil {
 		return nil, err
@@ -30,81 +43,86 @@ func getResponseBody(requestUrl string) (io.ReadCloser, error) {
 	return res.Body, nil
 }
 
-func handlerfunc(w *response.Writer, req *request.Request) {
-	url := req.RequestLine.RequestTarget
-
-	if strings.HasPrefix(url, "/httpbin") {
-		body, err := getResponseBody(req.RequestLine.RequestTarget)
+func httpbinHandler(w *response.Writer, req *request.Request) {
+	body, err := getResponseBody(req.PathValue("path"), req.URL.RawQuery)
+	if err != nil {
+		log.Println(err)
+		w.Error(response.StatusBadGateway, "")
+		return
+	}
+	defer body.Close()
+
+	w.DeclareTrailer("X-Content-SHA256", "X-Content-Length")
+
+	buffer := make([]byte, 64)
+	hash := sha256.New()
+	length := 0
+	for {
+		n, err := body.Read(buffer)
+		if n > 0 {
+			hash.Write(buffer[:n])
+			length += n
+			if _, err := w.Write(buffer[:n]); err != nil {
+				log.Println(err)
+				return
+			}
+			w.Flush()
+		}
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
+			// a body cut short must not look complete to the client
 			log.Println(err)
+			w.Abort()
 			return
 		}
+	}
 
-		w.WriteStatusLine(200)
-		headers, trailers := headers.NewHeaders(), headers.NewHeaders()
-		headers.Set("Transfer-Encoding", "chunked")
-		trailers.Set("X-Content-SHA256", "")
-		trailers.Set("X-Content-Length", "")
-
-		w.WriteHeaders(headers, []string{"Content-Length"}, trailers)
-
-		buffer := make([]byte, 64)
-		isEof := false
-		fullBody := bytes.NewBuffer([]byte{})
-		for {
-			n, err := body.Read(buffer)
-
-			if err != nil {
-				if err != io.EOF {
-					log.Println(err)
-					return
-				} else {
-					isEof = true
-				}
-			}
-			fullBody.Write(buffer[:n])
-
-			_, err = w.WriteChunkedBody(buffer[:n])
-			if err != nil {
-				log.Println(err)
-				return
-			}
-
-			if isEof {
-				_, err = w.WriteChunkedBodyDone()
-				if err != nil {
-					log.Println(err)
-				}
+	w.SetTrailer("X-Content-SHA256", hex.EncodeToString(hash.Sum(nil)))
+	w.SetTrailer("X-Content-Length", strconv.Itoa(length))
+}
 
-				hash := sha256.Sum256(fullBody.Bytes())
-				encodedHash := hex.EncodeToString(hash[:])
+func videoHandler(w *response.Writer, req *request.Request) {
+	file, err := os.ReadFile("assets/vim.mp4")
+	if err != nil {
+		log.Println("Error reading file:", err)
+		w.Error(response.StatusInternalServerError, "")
+		return
+	}
+	headers := headers.NewHeaders()
+	headers.Set("Content-Type", "video/mp4")
+	headers.Set("Content-Length", strconv.Itoa(len(file)))
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(headers, nil, nil)
+	w.WriteBody(file)
+}
 
-				trailers.Replace("X-Content-SHA256", encodedHash)
-				trailers.Replace("X-Content-Length", strconv.Itoa(len([]byte(encodedHash))))
+var upgrader = &websocket.Upgrader{EnableCompression: true}
 
-				err := w.WriteTrailers(trailers)
-				if err != nil {
-					log.Println(err)
-				}
-				return
-			}
-		}
+// websocketHandler echoes every message back until the client closes.
+func websocketHandler(w *response.Writer, req *request.Request) {
+	conn, err := upgrader.Upgrade(w, req)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer conn.Close()
 
-	} else if url == "/video" {
-		w.WriteStatusLine(200)
-		headers := headers.NewHeaders()
-		headers.Set("Content-Type", "video/mp4")
-		file, err := os.ReadFile("assets/vim.mp4")
+	for {
+		typ, msg, err := conn.ReadMessage()
 		if err != nil {
-			log.Println("Error reading file:", err)
 			return
 		}
-		headers.Set("Content-Length", strconv.Itoa(len(file)))
-		w.WriteHeaders(headers, nil, nil)
-		w.WriteBody(file)
-	} else {
+		if err := conn.WriteMessage(typ, msg); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
 
-		data := []byte(`
+func indexHandler(w *response.Writer, req *request.Request) {
+	data := []byte(`
 		<html>
 			<head>
 				<title>200 OK</title>
@@ -114,25 +132,70 @@ func handlerfunc(w *response.Writer, req *request.Request) {
     			<p>Your request was an absolute banger.</p>
 			</body>
 		</html>`)
-		headers := headers.NewHeaders()
-		headers.Set("Content-Length", strconv.Itoa(len(data)))
-		headers.Set("Content-Type", "text/html")
-		w.WriteStatusLine(200)
-		w.WriteHeaders(headers, nil, nil)
-		w.WriteBody(data)
-	}
+	headers := headers.NewHeaders()
+	headers.Set("Content-Type", "text/html")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(headers, nil, nil)
+	w.WriteBody(data)
+}
 
+func newHandler() server.Handler {
+	r := router.New()
+	r.Get("/httpbin/{path...}", httpbinHandler)
+	r.Get("/video", videoHandler)
+	r.Get("/ws", websocketHandler)
+	r.Handle("/{path...}", indexHandler)
+
+	return server.Chain(
+		middleware.Recover(),
+		middleware.RequestID(),
+		middleware.AccessLog(log.Default()),
+		middleware.Timing(SLOW_REQUEST, log.Default()),
+	)(r.ServeHTTP)
 }
+
 func main() {
-	server, err := server.Serve(PORT, handlerfunc)
+	certFile := flag.String("tls-cert", "", "serve HTTPS with this certificate file")
+	keyFile := flag.String("tls-key", "", "private key for -tls-cert")
+	devTLS := flag.Bool("dev-tls", false, "serve HTTPS with a generated self-signed certificate")
+	flag.Parse()
+
+	config := server.DefaultConfig()
+	var certs *server.CertStore
+	if *devTLS {
+		*certFile, *keyFile = "tmp/dev-cert.pem", "tmp/dev-key.pem"
+		err := server.GenerateSelfSignedCert(*certFile, *keyFile, "localhost", "127.0.0.1")
+		if err != nil {
+			log.Fatalf("Error generating certificate: %v", err)
+		}
+	}
+	if *certFile != "" {
+		var err error
+		certs, err = server.NewCertStore(server.CertFile{CertFile: *certFile, KeyFile: *keyFile})
+		if err != nil {
+			log.Fatalf("Error loading certificate: %v", err)
+		}
+		config.TLSConfig = certs.TLSConfig()
+	}
+
+	server, err := server.ServeWithConfig(PORT, newHandler(), config)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
+	if certs != nil {
+		server.RegisterOnShutdown(certs.Watch(CERT_WATCH_INTERVAL))
+	}
 	log.Println("Server started on port", PORT)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("Server forced to stop:", err)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"tcpTohttp/internal/request"
@@ -62,10 +63,15 @@ func main() {
 			req.RequestLine.Method,
 			req.RequestLine.RequestTarget,
 			req.RequestLine.HttpVersion)
-		for key, val := range req.Headers.Headers {
+		req.Headers.Range(func(key, val string) bool {
 			fmt.Printf("- %v: %v\n", key, val)
+			return true
+		})
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			log.Fatal(err)
 		}
-		fmt.Printf("Body:\n%v\n", string(req.Body))
+		fmt.Printf("Body:\n%v\n", string(body))
 		cnn.Close()
 	}
 
@@ -3,39 +3,120 @@ package headers
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"strings"
 	"unicode"
 )
 
+// Headers is an ordered list of header fields. Every field instance is kept
+// with its original name casing and serializes in the order it was added;
+// lookups by name are case-insensitive.
 type Headers struct {
-	Headers map[string]string
+	fields []field
+
+	maxFields int
+	maxBytes  int
+	count     int
+	bytes     int
+}
+
+type field struct {
+	name  string
+	value string
 }
 
+// SetLimits bounds the number of fields and the bytes Parse accepts, across
+// calls. Zero means no limit.
+func (h *Headers) SetLimits(maxFields, maxBytes int) {
+	h.maxFields, h.maxBytes = maxFields, maxBytes
+}
+
+// Get returns the values of every field named key joined by commas, the
+// combined form of a list field. Use Values for fields such as Set-Cookie
+// that must not be combined.
 func (h *Headers) Get(key string) string {
-	key = strings.ToLower(key)
+	return strings.Join(h.Values(key), ",")
+}
+
+// Values returns the value of each field named key, in order.
+func (h *Headers) Values(key string) []string {
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			values = append(values, f.value)
+		}
+	}
+	return values
+}
+
+func (h *Headers) Has(key string) bool {
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			return true
+		}
+	}
+	return false
+}
 
-	return h.Headers[key]
+// Add appends a field, keeping any existing field with the same name.
+func (h *Headers) Add(key, val string) {
+	h.fields = append(h.fields, field{name: key, value: val})
 }
 
+// Set replaces every field named key with a single one, in the position of
+// the first.
 func (h *Headers) Set(key, val string) {
-	key = strings.ToLower(key)
+	for i, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			h.fields[i] = field{name: key, value: val}
+			h.del(key, i+1)
+			return
+		}
+	}
+	h.Add(key, val)
+}
 
-	if oldVal, ok := h.Headers[key]; ok {
-		val = oldVal + ", " + val
+// Del removes every field named key.
+func (h *Headers) Del(key string) {
+	h.del(key, 0)
+}
+
+func (h *Headers) del(key string, from int) {
+	kept := h.fields[:from]
+	for _, f := range h.fields[from:] {
+		if !strings.EqualFold(f.name, key) {
+			kept = append(kept, f)
+		}
 	}
-	h.Headers[key] = val
+	clear(h.fields[len(kept):])
+	h.fields = kept
 }
-func (h *Headers) Replace(key, val string) {
-	key = strings.ToLower(key)
-	h.Headers[key] = val
+
+// Range calls f for each field in order until f returns false.
+func (h *Headers) Range(f func(key, val string) bool) {
+	for _, field := range h.fields {
+		if !f(field.name, field.value) {
+			return
+		}
+	}
 }
-func (h *Headers) Delete(key string) {
-	delete(h.Headers, strings.ToLower(key))
+
+func (h *Headers) Len() int {
+	return len(h.fields)
 }
 
 const CRLF = "\r\n"
 
-func isTChar(s string) bool {
+var (
+	ErrMalformedHeader    = errors.New("malformed header line")
+	ErrInvalidHeaderName  = errors.New("invalid header name")
+	ErrInvalidHeaderValue = errors.New("invalid header value")
+	ErrTooLarge           = errors.New("header section too large")
+)
+
+// IsToken reports whether s is a non-empty token, the syntax of header
+// names and methods.
+func IsToken(s string) bool {
 	if len(s) < 1 {
 		return false
 	}
@@ -43,9 +124,10 @@ func isTChar(s string) bool {
 	valid := "!#$%&'*+-.^_`|~"
 
 	for _, c := range s {
-		if unicode.IsLetter(c) ||
-			unicode.IsDigit(c) ||
-			strings.ContainsRune(valid, c) {
+		if c <= unicode.MaxASCII &&
+			(unicode.IsLetter(c) ||
+				unicode.IsDigit(c) ||
+				strings.ContainsRune(valid, c)) {
 			continue
 		}
 		return false
@@ -53,17 +135,47 @@ func isTChar(s string) bool {
 	return true
 }
 
+// isFieldValue reports whether s is free of control characters other than
+// HTAB, which includes stray CR and LF.
+func isFieldValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < ' ' && s[i] != '\t') || s[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckField makes sure key and val can be sent as a field line: the name
+// must be a token and the value free of control characters, so a CR or LF
+// cannot end the line early and smuggle in another field.
+func CheckField(key, val string) error {
+	if !IsToken(key) {
+		return fmt.Errorf("%w: %q", ErrInvalidHeaderName, key)
+	}
+	if !isFieldValue(val) {
+		return fmt.Errorf("%w: control character in %q", ErrInvalidHeaderValue, key)
+	}
+	return nil
+}
+
 func ParseHeader(headerLine string) (string, string, error) {
 	firstColonIdx := strings.Index(headerLine, ":")
 	// println(headerLine)k
 	if firstColonIdx == -1 {
-		return "", "", errors.New("missing : in header")
+		return "", "", ErrMalformedHeader
 	}
 	key := headerLine[:firstColonIdx]
-	val := strings.TrimPrefix(headerLine[firstColonIdx+1:], " ")
+	val := strings.Trim(headerLine[firstColonIdx+1:], " \t")
 
-	if !isTChar(key) {
-		return "", "", errors.New("invalid key for header")
+	if strings.HasSuffix(key, " ") || strings.HasSuffix(key, "\t") {
+		return "", "", fmt.Errorf("%w: whitespace before colon in %q", ErrMalformedHeader, key)
+	}
+	if !IsToken(key) {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidHeaderName, key)
+	}
+	if !isFieldValue(val) {
+		return "", "", fmt.Errorf("%w: control character in %q", ErrMalformedHeader, key)
 	}
 
 	return key, val, nil
@@ -77,14 +189,28 @@ func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 		var headerLine string
 
 		if idx := bytes.Index(data[read:], []byte(CRLF)); idx == -1 {
+			if bytes.IndexByte(data[read:], '\n') != -1 {
+				return 0, false, fmt.Errorf("%w: bare LF", ErrMalformedHeader)
+			}
+			if h.maxBytes > 0 && h.bytes+len(data)-read > h.maxBytes {
+				return 0, false, fmt.Errorf("%w: over %d bytes", ErrTooLarge, h.maxBytes)
+			}
 			return read, false, nil
 		} else {
-			headerLine = string(bytes.Trim(data[read:read+idx], " "))
+			line := data[read : read+idx]
 			read += idx + len(CRLF)
-			if len(headerLine) == 0 {
+			h.bytes += idx + len(CRLF)
+			if h.maxBytes > 0 && h.bytes > h.maxBytes {
+				return 0, false, fmt.Errorf("%w: over %d bytes", ErrTooLarge, h.maxBytes)
+			}
+			if len(line) == 0 {
 				break
 			}
-
+			// obs-fold continuation lines are rejected rather than unfolded
+			if line[0] == ' ' || line[0] == '\t' {
+				return 0, false, fmt.Errorf("%w: line folding", ErrMalformedHeader)
+			}
+			headerLine = string(bytes.TrimRight(line, " \t"))
 		}
 
 		key, val, err := ParseHeader(headerLine)
@@ -92,8 +218,12 @@ func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 		if err != nil {
 			return 0, false, err
 		}
+		h.count++
+		if h.maxFields > 0 && h.count > h.maxFields {
+			return 0, false, fmt.Errorf("%w: over %d fields", ErrTooLarge, h.maxFields)
+		}
 
-		h.Set(key, val)
+		h.Add(key, val)
 	}
 
 	return read, true, nil
@@ -101,5 +231,5 @@ func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 }
 
 func NewHeaders() *Headers {
-	return &Headers{Headers: make(map[string]string)}
+	return &Headers{}
 }
@@ -54,11 +54,43 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
+	// a whitespace only line is an obs-fold, not the end of the headers
 	headers = NewHeaders()
 	data = []byte(" \r\n")
 	n, done, err = headers.Parse(data)
 
+	require.ErrorIs(t, err, ErrMalformedHeader)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+}
+
+func TestHeadersMultiValue(t *testing.T) {
+	headers := NewHeaders()
+	data := []byte("Set-Cookie: a=1\r\nHost: example.com\r\nset-cookie: b=2; Path=/\r\n\r\n")
+	_, done, err := headers.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, 3, n)
-	assert.True(t, done)
+	require.True(t, done)
+
+	assert.Equal(t, []string{"a=1", "b=2; Path=/"}, headers.Values("Set-Cookie"))
+	assert.True(t, headers.Has("SET-COOKIE"))
+	assert.Equal(t, 3, headers.Len())
+
+	var order []string
+	headers.Range(func(key, val string) bool {
+		order = append(order, key)
+		return true
+	})
+	assert.Equal(t, []string{"Set-Cookie", "Host", "set-cookie"}, order)
+
+	headers.Set("Set-Cookie", "c=3")
+	assert.Equal(t, []string{"c=3"}, headers.Values("set-cookie"))
+	assert.Equal(t, 2, headers.Len())
+
+	headers.Add("Vary", "Accept")
+	headers.Add("Vary", "Origin")
+	assert.Equal(t, "Accept,Origin", headers.Get("vary"))
+
+	headers.Del("vary")
+	assert.False(t, headers.Has("Vary"))
+	assert.Nil(t, headers.Values("Vary"))
 }
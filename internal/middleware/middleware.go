@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strconv"
+	server "tcpTohttp/internal"
+	"tcpTohttp/internal/request"
+	"tcpTohttp/internal/response"
+	"time"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// Recover turns a panicking handler into a 500 response. If the response
+// was already committed it is cut short and the connection closed instead.
+func Recover() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			defer func() {
+				if rec := recover(); rec != nil {
+					server.RecoverPanic(w, req, rec)
+				}
+			}()
+			next(w, req)
+		}
+	}
+}
+
+// RequestID makes sure every request carries an X-Request-ID header,
+// generating one when the client sent none, and echoes it in the response.
+func RequestID() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id := req.Headers.Get(RequestIDHeader)
+			if id == "" {
+				id = newRequestID()
+				req.Headers.Set(RequestIDHeader, id)
+			}
+			w.Header().Set(RequestIDHeader, id)
+			next(w, req)
+		}
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AccessLog logs one line per request once the handler returns. A handler
+// that wrote nothing is logged with the 200 the server sends for it, and
+// one that panicked with a 500.
+func AccessLog(logger *log.Logger) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			defer func() {
+				status := w.StatusCode()
+				rec := recover()
+				if rec != nil {
+					status = response.StatusInternalServerError
+				} else if status == 0 {
+					status = response.StatusOK
+				}
+
+				id := req.Headers.Get(RequestIDHeader)
+				if id == "" {
+					id = "-"
+				}
+				logger.Printf("%s %s %s %d %d %s", id,
+					req.RequestLine.Method, req.RequestLine.RequestTarget,
+					status, w.BytesWritten(), w.Duration())
+
+				if rec != nil {
+					panic(rec)
+				}
+			}()
+			next(w, req)
+		}
+	}
+}
+
+// Timing logs requests that take longer than threshold to handle.
+func Timing(threshold time.Duration, logger *log.Logger) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+
+			if elapsed := time.Since(start); elapsed > threshold {
+				logger.Printf("slow request %s %s took %s",
+					req.RequestLine.Method, req.RequestLine.RequestTarget, elapsed)
+			}
+		}
+	}
+}
+
+// MaxBodyBytes overrides the server's body size limit for the routes it
+// wraps. Requests declaring a larger Content-Length get a 413 right away.
+func MaxBodyBytes(n int64) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			req.SetMaxBodyBytes(n)
+
+			contentLen, err := strconv.ParseInt(req.Headers.Get("Content-Length"), 10, 64)
+			if err == nil && contentLen > n {
+				w.KeepAlive = false
+				w.Error(response.StatusContentTooLarge, "request body too large")
+				return
+			}
+			next(w, req)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	server "tcpTohttp/internal"
+	"tcpTohttp/internal/request"
+	"tcpTohttp/internal/response"
+)
+
+// newRequest parses raw into a request whose body reads under limits.
+func newRequest(t *testing.T, raw string, limits request.Limits) *request.Request {
+	t.Helper()
+	req, err := request.NewReaderWithLimits(strings.NewReader(raw), limits).ReadRequest()
+	require.NoError(t, err)
+	return req
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	record := func(name string) server.Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				calls = append(calls, name+" in")
+				next(w, req)
+				calls = append(calls, name+" out")
+			}
+		}
+	}
+	h := server.Chain(record("a"), record("b"))(func(w *response.Writer, req *request.Request) {
+		calls = append(calls, "handler")
+	})
+
+	h(response.NewWriter(&bytes.Buffer{}, true),
+		newRequest(t, "GET / HTTP/1.1\r\nHost: x\r\n\r\n", request.DefaultLimits()))
+	assert.Equal(t, []string{"a in", "b in", "handler", "b out", "a out"}, calls)
+}
+
+func TestRecover(t *testing.T) {
+	h := Recover()(func(w *response.Writer, req *request.Request) {
+		w.Write([]byte("partial"))
+		if req.URL.Path == "/committed" {
+			w.Flush()
+		}
+		panic("boom")
+	})
+
+	// nothing sent yet: a 500 replaces the response
+	var out bytes.Buffer
+	w := response.NewWriter(&out, true)
+	h(w, newRequest(t, "GET / HTTP/1.1\r\nHost: x\r\n\r\n", request.DefaultLimits()))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 500 "))
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\ninternal server error"))
+	assert.False(t, w.KeepAlive)
+
+	// committed: the response is aborted where it stands
+	out.Reset()
+	w = response.NewWriter(&out, true)
+	h(w, newRequest(t, "GET /committed HTTP/1.1\r\nHost: x\r\n\r\n", request.DefaultLimits()))
+	sent := out.String()
+	assert.ErrorIs(t, w.Finish(), response.ErrAborted)
+	assert.Equal(t, response.StatusWriteFailed, w.Status())
+	assert.False(t, w.KeepAlive)
+	assert.True(t, strings.HasPrefix(sent, "HTTP/1.1 200 "))
+	assert.Equal(t, sent, out.String())
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	limits := request.DefaultLimits()
+	limits.MaxBodyBytes = 4
+	var body []byte
+	var readErr error
+	h := MaxBodyBytes(16)(func(w *response.Writer, req *request.Request) {
+		body, readErr = io.ReadAll(req.Body)
+		w.Write([]byte("ok"))
+	})
+
+	// the route takes a body over the server limit
+	var out bytes.Buffer
+	w := response.NewWriter(&out, true)
+	h(w, newRequest(t, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 10\r\n\r\n0123456789", limits))
+	require.NoError(t, w.Finish())
+	assert.NoError(t, readErr)
+	assert.Equal(t, "0123456789", string(body))
+	assert.True(t, w.KeepAlive)
+
+	// a declared length over its own limit is refused before the handler
+	body, readErr = nil, nil
+	out.Reset()
+	w = response.NewWriter(&out, true)
+	h(w, newRequest(t, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 17\r\n\r\n", limits))
+	require.NoError(t, w.Finish())
+	assert.Nil(t, body)
+	assert.Equal(t, response.StatusContentTooLarge, w.StatusCode())
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 413 "))
+	assert.False(t, w.KeepAlive)
+
+	// a chunked body declares no length and is cut off at the limit
+	h(response.NewWriter(&bytes.Buffer{}, true), newRequest(t,
+		"POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n11\r\n"+strings.Repeat("a", 17)+"\r\n0\r\n\r\n", limits))
+	assert.ErrorIs(t, readErr, request.ErrBodyTooLarge)
+}
+
+func TestAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	accessLog := AccessLog(log.New(&logs, "", 0))
+	get := func() *request.Request {
+		return newRequest(t, "GET /x HTTP/1.1\r\nHost: x\r\n\r\n", request.DefaultLimits())
+	}
+
+	// a handler that writes nothing gets the server's empty 200
+	accessLog(func(w *response.Writer, req *request.Request) {})(
+		response.NewWriter(&bytes.Buffer{}, true), get())
+	assert.True(t, strings.HasPrefix(logs.String(), "- GET /x 200 0 "))
+
+	// a panic is logged on its way out, inside or outside Recover
+	for _, h := range []server.Handler{
+		server.Chain(Recover(), accessLog)(func(w *response.Writer, req *request.Request) { panic("boom") }),
+		server.Chain(accessLog, Recover())(func(w *response.Writer, req *request.Request) { panic("boom") }),
+	} {
+		logs.Reset()
+		h(response.NewWriter(&bytes.Buffer{}, true), get())
+		assert.True(t, strings.HasPrefix(logs.String(), "- GET /x 500 "), logs.String())
+	}
+}
@@ -0,0 +1,168 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrBodyClosed = errors.New("read on closed request body")
+
+// body reads a request body from the connection, framed by Content-Length
+// or chunked encoding.
+type body struct {
+	rr        *Reader
+	req       *Request
+	remaining int64
+	// sticky error, io.EOF once the body was read to the end
+	err    error
+	closed bool
+}
+
+func newBody(rr *Reader, req *Request) *body {
+	b := &body{rr: rr, req: req}
+	if req.State == StateParseBody {
+		b.remaining = req.contentLen
+	}
+	if req.done() {
+		b.err = io.EOF
+		req.expectContinue = false
+	}
+	return b
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+	return b.read(p)
+}
+
+// Close stops the handler from reading further. What is left of the body
+// is discarded by the Reader before the next request.
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *body) read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	limit := b.req.maxBodyBytes
+	if limit > 0 && b.req.State == StateParseBody && b.remaining > limit {
+		b.err = fmt.Errorf("%w: over %d bytes", ErrBodyTooLarge, limit)
+		return 0, b.err
+	}
+	b.req.chunked.maxTotal = limit
+
+	if b.req.expectContinue {
+		b.req.expectContinue = false
+		if b.req.sendContinue != nil {
+			if b.err = b.req.sendContinue(); b.err != nil {
+				return 0, b.err
+			}
+		}
+	}
+
+	var n int
+	if b.req.State == StateParseChunkedBody {
+		n, b.err = b.readChunked(p)
+	} else {
+		n, b.err = b.readFixed(p)
+	}
+	if b.err == io.EOF {
+		b.req.State = StateDone
+	}
+	return n, b.err
+}
+
+func (b *body) readFixed(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	var n int
+	var err error
+	if b.rr.read > 0 {
+		n = copy(p, b.rr.buffer[:b.rr.read])
+		b.rr.consume(n)
+	} else {
+		n, err = b.rr.reader.Read(p)
+		if err == io.EOF {
+			err = nil
+			if n == 0 {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+	}
+
+	b.remaining -= int64(n)
+	if b.remaining == 0 {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func (b *body) readChunked(p []byte) (int, error) {
+	for {
+		n, payload, err := b.req.chunked.next(b.rr.buffer[:b.rr.read], len(p))
+		if err != nil {
+			return 0, err
+		}
+		// payload aliases the buffer, copy it out before consuming
+		copied := copy(p, payload)
+		b.rr.consume(n)
+
+		if b.req.chunked.done() {
+			return copied, io.EOF
+		}
+		if copied > 0 {
+			return copied, nil
+		}
+		if n > 0 {
+			continue
+		}
+
+		read, err := b.rr.fill()
+		if read > 0 {
+			continue
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// discard reads the rest of the body, giving up after limit bytes. A body
+// the client was never told to send may never come, so it is not waited
+// for.
+func (b *body) discard(limit int64) error {
+	if b.err == nil && b.req.expectContinue {
+		return ErrBodyNotConsumed
+	}
+	if b.err == nil && b.req.State == StateParseBody && b.remaining > limit {
+		return ErrBodyNotConsumed
+	}
+
+	buf := make([]byte, 4096)
+	var discarded int64
+	for b.err == nil {
+		if discarded > limit {
+			return ErrBodyNotConsumed
+		}
+		n, _ := b.read(buf)
+		discarded += int64(n)
+	}
+	if b.err == io.EOF {
+		return nil
+	}
+	return b.err
+}
@@ -0,0 +1,195 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"tcpTohttp/internal/headers"
+)
+
+// longest chunk size line accepted, extensions included
+const maxChunkLine = 4096
+
+var (
+	ErrMalformedChunk              = errors.New("malformed chunked encoding")
+	ErrUnsupportedTransferEncoding = errors.New("unsupported transfer encoding")
+)
+
+type chunkState int
+
+const (
+	chunkStateSize chunkState = iota
+	chunkStateData
+	chunkStateDataEnd
+	chunkStateTrailers
+	chunkStateDone
+)
+
+// chunkedParser decodes a chunked body incrementally. Each call to next
+// consumes what it can from the buffered data and returns at most one
+// piece of body payload.
+type chunkedParser struct {
+	state     chunkState
+	remaining int64
+	total     int64
+	// maxTotal caps the decoded body size, zero means no limit
+	maxTotal int64
+	trailers *headers.Headers
+}
+
+func (c *chunkedParser) done() bool {
+	return c.state == chunkStateDone
+}
+
+// next returns the number of bytes of data consumed and the payload found
+// in them, at most limit bytes aliasing data. It consumes nothing when data
+// holds no complete element yet.
+func (c *chunkedParser) next(data []byte, limit int) (int, []byte, error) {
+	read := 0
+	for {
+		switch c.state {
+		case chunkStateSize:
+			idx := bytes.Index(data[read:], []byte(CRLF))
+			if idx == -1 {
+				if len(data)-read > maxChunkLine {
+					return 0, nil, fmt.Errorf("%w: chunk size line too long", ErrMalformedChunk)
+				}
+				return read, nil, nil
+			}
+			size, err := parseChunkSize(string(data[read : read+idx]))
+			if err != nil {
+				return 0, nil, err
+			}
+			read += idx + len(CRLF)
+
+			if c.maxTotal > 0 && c.total+size > c.maxTotal {
+				return 0, nil, fmt.Errorf("%w: over %d bytes", ErrBodyTooLarge, c.maxTotal)
+			}
+			c.total += size
+			c.remaining = size
+			if size == 0 {
+				c.state = chunkStateTrailers
+			} else {
+				c.state = chunkStateData
+			}
+
+		case chunkStateData:
+			if read == len(data) {
+				return read, nil, nil
+			}
+			n := int(min(c.remaining, int64(len(data)-read), int64(limit)))
+			payload := data[read : read+n]
+			read += n
+			c.remaining -= int64(n)
+			if c.remaining == 0 {
+				c.state = chunkStateDataEnd
+			}
+			return read, payload, nil
+
+		case chunkStateDataEnd:
+			if len(data)-read < len(CRLF) {
+				return read, nil, nil
+			}
+			if string(data[read:read+len(CRLF)]) != CRLF {
+				return 0, nil, fmt.Errorf("%w: missing CRLF after chunk data", ErrMalformedChunk)
+			}
+			read += len(CRLF)
+			c.state = chunkStateSize
+
+		case chunkStateTrailers:
+			n, done, err := c.trailers.Parse(data[read:])
+			if err != nil {
+				return 0, nil, err
+			}
+			read += n
+			if !done {
+				return read, nil, nil
+			}
+			c.state = chunkStateDone
+
+		case chunkStateDone:
+			return read, nil, nil
+		}
+	}
+}
+
+// parseChunkSize parses "1a;name=value" into 0x1a. Extensions are checked
+// for syntax and otherwise ignored.
+func parseChunkSize(line string) (int64, error) {
+	sizeStr, ext, _ := strings.Cut(line, ";")
+	sizeStr = strings.TrimRight(sizeStr, " \t")
+
+	if sizeStr == "" || len(sizeStr) > 15 {
+		return 0, fmt.Errorf("%w: chunk size %q", ErrMalformedChunk, sizeStr)
+	}
+	size, err := strconv.ParseInt(sizeStr, 16, 64)
+	if err != nil || strings.HasPrefix(sizeStr, "+") || strings.HasPrefix(sizeStr, "-") {
+		return 0, fmt.Errorf("%w: chunk size %q", ErrMalformedChunk, sizeStr)
+	}
+
+	for ext != "" {
+		var e string
+		e, ext = cutChunkExt(ext)
+		name, val, _ := strings.Cut(strings.TrimSpace(e), "=")
+		if !headers.IsToken(strings.TrimSpace(name)) {
+			return 0, fmt.Errorf("%w: chunk extension %q", ErrMalformedChunk, e)
+		}
+		val = strings.TrimSpace(val)
+		if strings.HasPrefix(val, "\"") {
+			if !isQuotedString(val) {
+				return 0, fmt.Errorf("%w: chunk extension %q", ErrMalformedChunk, e)
+			}
+		} else if val != "" && !headers.IsToken(val) {
+			return 0, fmt.Errorf("%w: chunk extension %q", ErrMalformedChunk, e)
+		}
+	}
+	return size, nil
+}
+
+// cutChunkExt cuts ext around the first ";" outside a quoted string.
+func cutChunkExt(ext string) (before, after string) {
+	quoted := false
+	for i := 0; i < len(ext); i++ {
+		switch c := ext[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == ';':
+			return ext[:i], ext[i+1:]
+		}
+	}
+	return ext, ""
+}
+
+// isQuotedString reports whether s is a single quoted string, backslash
+// escapes included.
+func isQuotedString(s string) bool {
+	if len(s) < 2 || s[0] != '"' {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i == len(s)-1
+		}
+	}
+	return false
+}
+
+// isChunked reports whether the request body uses chunked framing. Any
+// other transfer coding is rejected since the server cannot decode it.
+func (r *Request) isChunked() (bool, error) {
+	te := r.Headers.Get("Transfer-Encoding")
+	if te == "" {
+		return false, nil
+	}
+	if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+		return false, fmt.Errorf("%w: %q", ErrUnsupportedTransferEncoding, te)
+	}
+	return true, nil
+}
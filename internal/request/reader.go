@@ -0,0 +1,147 @@
+package request
+
+import (
+	"errors"
+	"io"
+	"tcpTohttp/internal/headers"
+)
+
+// MaxDiscardBytes is how much of an unread body the Reader drops to get to
+// the next request before giving up on the connection.
+const MaxDiscardBytes = 256 << 10
+
+var (
+	ErrBodyNotConsumed = errors.New("request body too large to discard")
+	ErrDetached        = errors.New("connection taken over from the reader")
+)
+
+// Reader reads consecutive requests from one connection. Bytes read past
+// the end of a request stay buffered and start the next one.
+type Reader struct {
+	reader io.Reader
+	buffer []byte
+	read   int
+	limits Limits
+
+	// body of the last request, which must be consumed before the next
+	body *body
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return NewReaderWithLimits(reader, DefaultLimits())
+}
+
+func NewReaderWithLimits(reader io.Reader, limits Limits) *Reader {
+	return &Reader{reader: reader, buffer: make([]byte, 1024), limits: limits}
+}
+
+// Buffered returns the number of bytes already read from the connection
+// that belong to the next request.
+func (rr *Reader) Buffered() int {
+	return rr.read
+}
+
+// Detach gives up the connection, returning the bytes read from it that no
+// request consumed yet, body bytes included. The body of the last request
+// reads nothing more from then on.
+func (rr *Reader) Detach() []byte {
+	buffered := make([]byte, rr.read)
+	copy(buffered, rr.buffer[:rr.read])
+	rr.read = 0
+	if rr.body != nil {
+		rr.body.err = ErrDetached
+		rr.body = nil
+	}
+	return buffered
+}
+
+// Wait blocks until at least one byte of the next request is buffered.
+func (rr *Reader) Wait() error {
+	if rr.read > 0 {
+		return nil
+	}
+	n, err := rr.reader.Read(rr.buffer)
+	rr.read += n
+	if n > 0 {
+		return nil
+	}
+	return err
+}
+
+// ReadRequest parses the request line and headers of the next request,
+// discarding what the previous request left of its body. It returns io.EOF
+// if the connection was closed before any byte of a new request arrived.
+func (rr *Reader) ReadRequest() (*Request, error) {
+	if err := rr.DiscardBody(); err != nil {
+		return nil, err
+	}
+
+	req := &Request{State: StateInitialized,
+		Headers:      *headers.NewHeaders(),
+		limits:       rr.limits,
+		maxBodyBytes: rr.limits.MaxBodyBytes}
+	req.Headers.SetLimits(rr.limits.MaxHeaderCount, rr.limits.MaxHeaderBytes)
+	started := rr.read > 0
+
+	for {
+		n, err := req.parse(rr.buffer[:rr.read])
+		if err != nil {
+			return nil, err
+		}
+		rr.consume(n)
+
+		if req.State >= StateParseBody {
+			break
+		}
+
+		n, err = rr.fill()
+		if n > 0 {
+			started = true
+			continue
+		}
+		if err == io.EOF && started {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rr.body = newBody(rr, req)
+	req.Body = rr.body
+	return req, nil
+}
+
+// DiscardBody reads and drops what is left of the last request's body. It
+// returns the error the body failed with, or ErrBodyNotConsumed if more
+// than MaxDiscardBytes were left; either way the connection cannot be
+// reused.
+func (rr *Reader) DiscardBody() error {
+	if rr.body == nil {
+		return nil
+	}
+	err := rr.body.discard(MaxDiscardBytes)
+	if err == nil {
+		rr.body = nil
+	}
+	return err
+}
+
+// fill reads more bytes from the connection, growing the buffer when it is
+// full.
+func (rr *Reader) fill() (int, error) {
+	if rr.read == len(rr.buffer) {
+		newBuffer := make([]byte, len(rr.buffer)*2)
+		copy(newBuffer, rr.buffer)
+		rr.buffer = newBuffer
+	}
+	n, err := rr.reader.Read(rr.buffer[rr.read:])
+	rr.read += n
+	return n, err
+}
+
+// consume drops the first n buffered bytes.
+func (rr *Reader) consume(n int) {
+	copy(rr.buffer, rr.buffer[n:rr.read])
+	rr.read -= n
+}
@@ -3,20 +3,69 @@ package request
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"log"
-	"slices"
 	"strconv"
 	"strings"
 	"tcpTohttp/internal/headers"
-	"unicode"
 )
 
 type Request struct {
 	RequestLine RequestLine
-	Headers     headers.Headers
-	State       State
-	Body        []byte
+	// URL is the parsed RequestLine.RequestTarget.
+	URL     *URL
+	Headers headers.Headers
+	State   State
+	// Body streams the request body from the connection. It returns io.EOF
+	// right away for requests without one.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields of a chunked body once Body has
+	// been read to the end.
+	Trailers headers.Headers
+
+	limits       Limits
+	contentLen   int64
+	maxBodyBytes int64
+	chunked      chunkedParser
+	pathValues   map[string]string
+
+	// expectContinue is set while the client waits for 100 Continue
+	expectContinue bool
+	sendContinue   func() error
+}
+
+// ExpectsContinue reports whether the client sent "Expect: 100-continue"
+// and is still waiting to be told to send the body.
+func (r *Request) ExpectsContinue() bool {
+	return r.expectContinue
+}
+
+// SetContinue sets the function that sends the 100 Continue response. Body
+// calls it before its first read if the client expects it, so a handler
+// that answers without reading the body never asks for it.
+func (r *Request) SetContinue(f func() error) {
+	r.sendContinue = f
+}
+
+// SetMaxBodyBytes overrides the body size limit for this request, for
+// routes that accept larger uploads or want to reject smaller ones. It has
+// no effect once the body has been read from.
+func (r *Request) SetMaxBodyBytes(n int64) {
+	r.maxBodyBytes = n
+}
+
+// PathValue returns the value of a path parameter set by the router, or ""
+// if the route has no parameter with that name.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
+	}
+	r.pathValues[name] = value
 }
 
 type State int
@@ -25,6 +74,7 @@ const (
 	StateInitialized State = iota
 	StateParsingHeaders
 	StateParseBody
+	StateParseChunkedBody
 	StateDone
 )
 
@@ -36,21 +86,65 @@ type RequestLine struct {
 
 const CRLF = "\r\n"
 
-func (r *Request) getContentLen() int {
-	contentLen := r.Headers.Get("Content-Length")
+// Parse errors, wrapped with details meant for logs only. The server maps
+// each of them to a response status.
+var (
+	ErrMalformedRequestLine = errors.New("malformed request line")
+	// ErrMethodNotImplemented is not returned by the parser, which accepts
+	// any method. The server wraps it for methods it does not implement.
+	ErrMethodNotImplemented = errors.New("method not implemented")
+	ErrUnsupportedVersion   = errors.New("unsupported http version")
+	ErrURITooLong           = errors.New("request uri too long")
+	ErrHeaderTooLarge       = headers.ErrTooLarge
+	ErrBodyTooLarge         = errors.New("request body too large")
+	ErrInvalidContentLength = errors.New("invalid content-length")
+	ErrAmbiguousFraming     = errors.New("both content-length and transfer-encoding")
+	ErrExpectationFailed    = errors.New("unsupported expectation")
+)
 
-	if contentLen == "" {
-		return 0
-	}
+// Limits bounds what a Reader accepts from a client. Zero means no limit.
+type Limits struct {
+	MaxRequestLineBytes int
+	// MaxHeaderBytes and MaxHeaderCount also apply to chunked trailers.
+	MaxHeaderBytes int
+	MaxHeaderCount int
+	MaxBodyBytes   int64
+}
+
+const (
+	DefaultMaxRequestLineBytes = 8 << 10
+	DefaultMaxHeaderBytes      = 1 << 20
+	DefaultMaxHeaderCount      = 100
+	DefaultMaxBodyBytes        = 10 << 20
+)
 
-	n, err := strconv.Atoi(contentLen)
-	if err != nil {
-		log.Fatal(err)
+func DefaultLimits() Limits {
+	return Limits{
+		MaxRequestLineBytes: DefaultMaxRequestLineBytes,
+		MaxHeaderBytes:      DefaultMaxHeaderBytes,
+		MaxHeaderCount:      DefaultMaxHeaderCount,
+		MaxBodyBytes:        DefaultMaxBodyBytes,
 	}
-	if n < 0 {
-		return 0
+}
+
+// contentLength parses the Content-Length field. Repeating it, as a list
+// or as separate fields, is only accepted when every value is the same.
+func (r *Request) contentLength() (int64, error) {
+	field := r.Headers.Get("Content-Length")
+
+	n := int64(-1)
+	for _, val := range strings.Split(field, ",") {
+		val = strings.TrimSpace(val)
+		if !isDigits(val) || len(val) > 18 {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, field)
+		}
+		parsed, _ := strconv.ParseInt(val, 10, 64)
+		if n != -1 && parsed != n {
+			return 0, fmt.Errorf("%w: conflicting values %q", ErrInvalidContentLength, field)
+		}
+		n = parsed
 	}
-	return n
+	return n, nil
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -62,13 +156,16 @@ func (r *Request) parse(data []byte) (int, error) {
 			return read, nil
 
 		case StateInitialized:
-			reqLine, n, err := parseRequestLine(data[read:])
+			reqLine, n, err := parseRequestLine(data[read:], r.limits.MaxRequestLineBytes)
 			if err != nil {
 				return 0, err
 			}
 			if n == 0 {
 				return 0, nil
 			}
+			if r.URL, err = parseTarget(reqLine.Method, reqLine.RequestTarget); err != nil {
+				return 0, err
+			}
 			r.RequestLine = reqLine
 			r.State = StateParsingHeaders
 			read += n
@@ -85,37 +182,51 @@ func (r *Request) parse(data []byte) (int, error) {
 				return read, nil
 			}
 
-			if r.getContentLen() > 0 {
-				r.State = StateParseBody
-
-			} else {
-				r.State = StateDone
+			hasContentLen := r.Headers.Has("Content-Length")
+			hasTransferEncoding := r.Headers.Has("Transfer-Encoding")
+			if hasContentLen && hasTransferEncoding {
+				return 0, ErrAmbiguousFraming
 			}
-
-		case StateParseBody:
-			currData := data[read:]
-			// too much data
-			if len(r.Body)+len(currData) > r.getContentLen() {
-				return 0, errors.New("too much data data")
-
+			// chunked framing does not exist in HTTP/1.0, a 1.0 message
+			// carrying it went through something that cannot be trusted
+			if hasTransferEncoding && r.RequestLine.HttpVersion == "1.0" {
+				return 0, fmt.Errorf("%w: transfer-encoding in HTTP/1.0", ErrAmbiguousFraming)
 			}
-			// body missing
-			if len(currData) == 0 && len(data) == 0 {
-				return 0, errors.New("not enough data")
+			if hasContentLen {
+				if r.contentLen, err = r.contentLength(); err != nil {
+					return 0, err
+				}
 			}
-			// more data need
-			if len(currData) == 0 {
-				return read, nil
+
+			// HTTP/1.0 predates Expect, its clients do not wait for 100
+			if expect := r.Headers.Get("Expect"); expect != "" &&
+				r.RequestLine.HttpVersion != "1.0" {
+				if !strings.EqualFold(strings.TrimSpace(expect), "100-continue") {
+					return 0, fmt.Errorf("%w: %q", ErrExpectationFailed, expect)
+				}
+				r.expectContinue = true
 			}
 
-			n := min(r.getContentLen()-len(r.Body), len(currData))
-			r.Body = append(r.Body, currData[:n]...)
-			read += n
+			chunked, err := r.isChunked()
+			if err != nil {
+				return 0, err
+			}
+			if chunked {
+				r.Trailers = *headers.NewHeaders()
+				r.Trailers.SetLimits(r.limits.MaxHeaderCount, r.limits.MaxHeaderBytes)
+				r.chunked = chunkedParser{trailers: &r.Trailers}
+				r.State = StateParseChunkedBody
+			} else if r.contentLen > 0 {
+				r.State = StateParseBody
 
-			if len(r.Body) == r.getContentLen() {
+			} else {
 				r.State = StateDone
 			}
 
+		case StateParseBody, StateParseChunkedBody:
+			// the body is streamed by Request.Body
+			return read, nil
+
 		default:
 			log.Fatal("state dose not match")
 		}
@@ -126,89 +237,93 @@ func (r Request) done() bool {
 	return r.State == StateDone
 }
 
-func parseRequestLine(data []byte) (RequestLine, int, error) {
+func parseRequestLine(data []byte, maxBytes int) (RequestLine, int, error) {
 
 	var reqLine []byte
 	req := RequestLine{}
 	read := 0
 	if i := bytes.Index(data, []byte(CRLF)); i == -1 {
+		if bytes.IndexByte(data, '\n') != -1 {
+			return req, 0, fmt.Errorf("%w: bare LF", ErrMalformedRequestLine)
+		}
+		if maxBytes > 0 && len(data) > maxBytes {
+			return req, 0, fmt.Errorf("%w: over %d bytes", ErrURITooLong, maxBytes)
+		}
 		return req, 0, nil
+	} else if maxBytes > 0 && i > maxBytes {
+		return req, 0, fmt.Errorf("%w: over %d bytes", ErrURITooLong, maxBytes)
 	} else {
 		reqLine = data[:i]
 		read = i + len(CRLF)
 	}
 
+	if bytes.ContainsAny(reqLine, "\r\n") {
+		return req, 0, fmt.Errorf("%w: bare CR or LF", ErrMalformedRequestLine)
+	}
 	reqPart := strings.Split(string(reqLine), " ")
 
 	if len(reqPart) != 3 {
-		return req, 0, errors.New("missing or to mangy arguments")
+		return req, 0, fmt.Errorf("%w: %d parts", ErrMalformedRequestLine, len(reqPart))
 	}
 	// GET /coffee HTTP/1.1
-	// validate method
-	methods := []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
-
-	if !slices.Contains(methods, reqPart[0]) {
-		return req, 0, errors.New("invalid method")
+	// validate method, any token is one. Which methods are implemented is
+	// up to the server.
+	if !headers.IsToken(reqPart[0]) {
+		return req, 0, fmt.Errorf("%w: method %q", ErrMalformedRequestLine, reqPart[0])
 	}
 	req.Method = reqPart[0]
 
 	// validate http vertion
-	httpv := strings.Split(reqPart[2], "/")
-	if !(len(httpv) == 2 && httpv[0] == "HTTP" && httpv[1] == "1.1") {
-		return req, 0, errors.New("invalid http vertions")
+	version, ok := strings.CutPrefix(reqPart[2], "HTTP/")
+	major, minor, found := strings.Cut(version, ".")
+	if !ok || !found || !isDigits(major) || !isDigits(minor) {
+		return req, 0, fmt.Errorf("%w: version %q", ErrMalformedRequestLine, reqPart[2])
 	}
-	req.HttpVersion = httpv[1]
-
-	// validate path
-	path := reqPart[1]
-	invalidChar := []string{"\\", " ", "+", "\n", "<", ">",
-		"|", "\"", "\\'", "{", "}", "^"}
-
-	if !strings.HasPrefix(path, "/") ||
-		slices.Contains(invalidChar, path) ||
-		!isAssci(path) {
-		return req, 0, errors.New("invalid path")
+	if version != "1.1" && version != "1.0" {
+		return req, 0, fmt.Errorf("%w: %q", ErrUnsupportedVersion, reqPart[2])
 	}
-	req.RequestTarget = path
+	req.HttpVersion = version
+
+	// the target is validated by parseTarget
+	req.RequestTarget = reqPart[1]
 
 	return req, read, nil
 }
-func isAssci(s string) bool {
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
 	for _, c := range s {
-		if c > unicode.MaxASCII {
+		if c < '0' || c > '9' {
 			return false
 		}
 	}
 	return true
 }
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	req := Request{State: StateInitialized,
-		Headers: *headers.NewHeaders()}
-
-	buffer := make([]byte, 1024)
-	read := 0
 
-	for !req.done() {
-		var n int
-		var err error
-		if read == len(buffer) {
-			newBuffer := make([]byte, len(buffer)*2)
-			copy(newBuffer, buffer)
-			buffer = newBuffer
-		}
+// KeepAlive reports whether the client allows the connection to be reused
+// after this request. HTTP/1.1 connections persist unless the client sends
+// "Connection: close", HTTP/1.0 ones only if it sends "Connection:
+// keep-alive".
+func (r *Request) KeepAlive() bool {
+	if r.RequestLine.HttpVersion == "1.0" {
+		return r.hasConnectionOption("keep-alive")
+	}
+	return !r.hasConnectionOption("close")
+}
 
-		n, err = reader.Read(buffer[read:])
-		if err != nil {
-			return nil, err
+func (r *Request) hasConnectionOption(option string) bool {
+	for _, opt := range strings.Split(r.Headers.Get("Connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(opt), option) {
+			return true
 		}
-		read += n
-		n, err = req.parse(buffer[:read])
-		if err != nil {
-			return nil, err
-		}
-		copy(buffer, buffer[n:read])
-		read -= n
-
 	}
-	return &req, nil
+	return false
+}
+
+// RequestFromReader reads a single request from reader. The body is read
+// lazily through Request.Body.
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	return NewReader(reader).ReadRequest()
 }
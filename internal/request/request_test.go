@@ -7,6 +7,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"tcpTohttp/internal/headers"
 )
 
 func TestRequestLineParse(t *testing.T) {
@@ -186,10 +187,10 @@ func TestPasingTheBody(t *testing.T) {
 			"hello world!\n",
 		numBytesPerRead: 3,
 	}
-	r, err := RequestFromReader(reader)
+	r, body, err := readFull(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "hello world!\n", string(body))
 
 	// Test: Body shorter than reported content length
 	reader = &chunkReader{
@@ -200,8 +201,8 @@ func TestPasingTheBody(t *testing.T) {
 			"partial content",
 		numBytesPerRead: 3,
 	}
-	r, err = RequestFromReader(reader)
-	require.Error(t, err)
+	_, _, err = readFull(reader)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
 
 	reader = &chunkReader{
 		data: "POST /submit HTTP/1.1\r\n" +
@@ -211,8 +212,10 @@ func TestPasingTheBody(t *testing.T) {
 			"more content",
 		numBytesPerRead: 30,
 	}
-	r, err = RequestFromReader(reader)
-	require.Error(t, err)
+	// the body ends at Content-Length, the rest belongs to the next request
+	_, body, err = readFull(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "more ", string(body))
 
 	reader = &chunkReader{
 		data: "POST /submit HTTP/1.1\r\n" +
@@ -221,10 +224,20 @@ func TestPasingTheBody(t *testing.T) {
 			"\r\n",
 		numBytesPerRead: 3,
 	}
-	r, err = RequestFromReader(reader)
+	_, _, err = readFull(reader)
 	require.Error(t, err)
 }
 
+// readFull reads a request and its whole body.
+func readFull(reader io.Reader) (*Request, []byte, error) {
+	r, err := RequestFromReader(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := io.ReadAll(r.Body)
+	return r, body, err
+}
+
 type chunkReader struct {
 	data            string
 	numBytesPerRead int
@@ -243,3 +256,295 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 
 	return n, nil
 }
+
+func TestChunkedBody(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7;name=\"a b\"\r\n world!\r\n" +
+			"1;a=\"x;y\";b=\"q\\\";z\"\r\n!\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, body, err := readFull(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!!", string(body))
+	assert.Equal(t, "abc", r.Trailers.Get("X-Checksum"))
+
+	tests := []struct {
+		body string
+		err  error
+	}{
+		{"zz\r\nhello\r\n0\r\n\r\n", ErrMalformedChunk},
+		{"5\r\nhelloXX0\r\n\r\n", ErrMalformedChunk},
+		{"5;=x\r\nhello\r\n0\r\n\r\n", ErrMalformedChunk},
+		{"5;a=\"x;y\r\nhello\r\n0\r\n\r\n", ErrMalformedChunk},
+		{"5;a=\"x\"y\r\nhello\r\n0\r\n\r\n", ErrMalformedChunk},
+		{"-5\r\nhello\r\n0\r\n\r\n", ErrMalformedChunk},
+		{"ffffffff\r\n", ErrBodyTooLarge},
+		{"5\r\nhel", io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		_, _, err := readFull(strings.NewReader(
+			"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" + tt.body))
+		assert.ErrorIs(t, err, tt.err, tt.body)
+	}
+
+	_, err = RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrUnsupportedTransferEncoding)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  error
+	}{
+		{"GET /\r\n\r\n", ErrMalformedRequestLine},
+		{"GET / HTTP\r\n\r\n", ErrMalformedRequestLine},
+		{"G(T / HTTP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"GET coffee HTTP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"BR@W / HTTP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"GET / HTTP/2.0\r\n\r\n", ErrUnsupportedVersion},
+		{"GET / HTTP/1.1\r\nHost localhost\r\n\r\n", headers.ErrMalformedHeader},
+		{"GET / HTTP/1.1\r\nH@st: localhost\r\n\r\n", headers.ErrInvalidHeaderName},
+	}
+	for _, tt := range tests {
+		_, err := RequestFromReader(strings.NewReader(tt.data))
+		assert.ErrorIs(t, err, tt.err, tt.data)
+	}
+}
+
+func TestExtensionMethods(t *testing.T) {
+	for _, method := range []string{"HEAD", "OPTIONS", "TRACE", "PROPFIND", "BREW"} {
+		r, err := RequestFromReader(strings.NewReader(method + " / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err, method)
+		assert.Equal(t, method, r.RequestLine.Method)
+	}
+
+	r, err := RequestFromReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, FormAuthority, r.URL.Form)
+	assert.Equal(t, "example.com:443", r.URL.Host)
+
+	r, err = RequestFromReader(strings.NewReader("OPTIONS * HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, FormAsterisk, r.URL.Form)
+}
+
+func TestHTTP10(t *testing.T) {
+	r, body, err := readFull(strings.NewReader(
+		"POST /form HTTP/1.0\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello",
+	))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.Equal(t, "hello", string(body))
+	assert.False(t, r.KeepAlive())
+
+	r, err = RequestFromReader(strings.NewReader(
+		"GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	r, err = RequestFromReader(strings.NewReader(
+		"GET / HTTP/1.1\r\nConnection: keep-alive, close\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+
+	// chunked framing is not part of HTTP/1.0
+	_, err = RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrAmbiguousFraming)
+
+	for _, version := range []string{"HTTP/0.9", "HTTP/1.2", "HTTP/2.0", "HTTP/3.0"} {
+		_, err = RequestFromReader(strings.NewReader("GET / " + version + "\r\n\r\n"))
+		assert.ErrorIs(t, err, ErrUnsupportedVersion, version)
+	}
+}
+
+func TestReaderPipelined(t *testing.T) {
+	reader := NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /next HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Connection: close\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	})
+
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/submit", r.RequestLine.RequestTarget)
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.True(t, r.KeepAlive())
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+	assert.False(t, r.KeepAlive())
+
+	_, err = reader.ReadRequest()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReaderDiscardsUnreadBody(t *testing.T) {
+	reader := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n0\r\n\r\n" +
+			"GET /next HTTP/1.1\r\n" +
+			"\r\n" +
+			"POST /big HTTP/1.1\r\n" +
+			"Content-Length: 1000000\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	})
+
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	_, err = r.Body.Read(buf)
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	_, err = r.Body.Read(buf)
+	assert.ErrorIs(t, err, ErrBodyClosed)
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	_, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.ErrorIs(t, reader.DiscardBody(), ErrBodyNotConsumed)
+}
+
+func TestReaderDetach(t *testing.T) {
+	reader := NewReader(strings.NewReader(
+		"GET /chat HTTP/1.1\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Content-Length: 2\r\n" +
+			"\r\n" +
+			"hi\x81\x00"))
+
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	// unread body bytes go along with what followed them
+	assert.Equal(t, []byte("hi\x81\x00"), reader.Detach())
+	assert.Zero(t, reader.Buffered())
+	_, err = r.Body.Read(make([]byte, 2))
+	assert.ErrorIs(t, err, ErrDetached)
+	require.NoError(t, reader.DiscardBody())
+}
+
+func TestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineBytes: 32,
+		MaxHeaderBytes:      64,
+		MaxHeaderCount:      3,
+		MaxBodyBytes:        8,
+	}
+	read := func(data string) (*Request, []byte, error) {
+		r, err := NewReaderWithLimits(strings.NewReader(data), limits).ReadRequest()
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := io.ReadAll(r.Body)
+		return r, body, err
+	}
+
+	_, _, err := read("GET /" + strings.Repeat("a", 40) + " HTTP/1.1\r\n\r\n")
+	assert.ErrorIs(t, err, ErrURITooLong)
+
+	// no CRLF in sight yet
+	_, _, err = read("GET /" + strings.Repeat("a", 40))
+	assert.ErrorIs(t, err, ErrURITooLong)
+
+	_, _, err = read("GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", 80) + "\r\n\r\n")
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	_, _, err = read("GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n")
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	_, _, err = read("POST / HTTP/1.1\r\nContent-Length: 9\r\n\r\n123456789")
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	_, _, err = read("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\n12345\r\n5\r\n67890\r\n0\r\n\r\n")
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	_, body, err := read("POST / HTTP/1.1\r\nContent-Length: 8\r\n\r\n12345678")
+	require.NoError(t, err)
+	assert.Equal(t, "12345678", string(body))
+
+	// raised for a single request
+	r, err := NewReaderWithLimits(strings.NewReader(
+		"POST / HTTP/1.1\r\nContent-Length: 9\r\n\r\n123456789"), limits).ReadRequest()
+	require.NoError(t, err)
+	r.SetMaxBodyBytes(16)
+	body, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "123456789", string(body))
+}
+
+func TestExpectContinue(t *testing.T) {
+	data := "POST /upload HTTP/1.1\r\n" +
+		"Expect: 100-Continue\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello"
+
+	r, err := RequestFromReader(strings.NewReader(data))
+	require.NoError(t, err)
+	assert.True(t, r.ExpectsContinue())
+
+	sent := 0
+	r.SetContinue(func() error {
+		sent++
+		return nil
+	})
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, 1, sent)
+	assert.False(t, r.ExpectsContinue())
+
+	// a body the client was never asked for is not waited for
+	reader := NewReader(strings.NewReader(data))
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.ErrorIs(t, reader.DiscardBody(), ErrBodyNotConsumed)
+
+	// nothing to wait for without a body
+	r, err = RequestFromReader(strings.NewReader(
+		"GET / HTTP/1.1\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+
+	r, err = RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.0\r\nExpect: 100-continue\r\nContent-Length: 1\r\n\r\na"))
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+
+	_, err = RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.1\r\nExpect: 200-ok\r\nContent-Length: 1\r\n\r\na"))
+	assert.ErrorIs(t, err, ErrExpectationFailed)
+}
@@ -0,0 +1,166 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcpTohttp/internal/headers"
+)
+
+// Known request smuggling payloads. Each of them must be rejected, since a
+// proxy in front of the server may frame the message differently.
+func TestSmugglingPayloads(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{
+			"CL.CL conflicting list",
+			"POST / HTTP/1.1\r\nContent-Length: 5, 7\r\n\r\nhello",
+			ErrInvalidContentLength,
+		},
+		{
+			"CL.CL conflicting fields",
+			"POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 7\r\n\r\nhello",
+			ErrInvalidContentLength,
+		},
+		{
+			"non numeric CL",
+			"POST / HTTP/1.1\r\nContent-Length: 0x5\r\n\r\nhello",
+			ErrInvalidContentLength,
+		},
+		{
+			"signed CL",
+			"POST / HTTP/1.1\r\nContent-Length: +5\r\n\r\nhello",
+			ErrInvalidContentLength,
+		},
+		{
+			"negative CL",
+			"POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n",
+			ErrInvalidContentLength,
+		},
+		{
+			"empty CL",
+			"POST / HTTP/1.1\r\nContent-Length:\r\n\r\n",
+			ErrInvalidContentLength,
+		},
+		{
+			"overflowing CL",
+			"POST / HTTP/1.1\r\nContent-Length: 99999999999999999999\r\n\r\n",
+			ErrInvalidContentLength,
+		},
+		{
+			"CL.TE",
+			"POST / HTTP/1.1\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG",
+			ErrAmbiguousFraming,
+		},
+		{
+			"TE.CL",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n8\r\nSMUGGLED\r\n0\r\n\r\n",
+			ErrAmbiguousFraming,
+		},
+		{
+			"TE.TE obfuscated coding",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: xchunked\r\n\r\n0\r\n\r\n",
+			ErrUnsupportedTransferEncoding,
+		},
+		{
+			"TE.TE duplicate coding",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: identity\r\n\r\n0\r\n\r\n",
+			ErrUnsupportedTransferEncoding,
+		},
+		{
+			"space before colon",
+			"POST / HTTP/1.1\r\nTransfer-Encoding : chunked\r\n\r\n0\r\n\r\n",
+			headers.ErrMalformedHeader,
+		},
+		{
+			"tab before colon",
+			"POST / HTTP/1.1\r\nContent-Length\t: 5\r\n\r\nhello",
+			headers.ErrMalformedHeader,
+		},
+		{
+			"obs-fold",
+			"POST / HTTP/1.1\r\nX-Foo: bar\r\n Transfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			headers.ErrMalformedHeader,
+		},
+		{
+			"obs-fold with tab",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: identity\r\n\tchunked\r\n\r\n0\r\n\r\n",
+			headers.ErrMalformedHeader,
+		},
+		{
+			"bare LF in headers",
+			"POST / HTTP/1.1\r\nX-Foo: bar\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			headers.ErrMalformedHeader,
+		},
+		{
+			"bare LF ending headers",
+			"GET / HTTP/1.1\r\nHost: x\n\n",
+			headers.ErrMalformedHeader,
+		},
+		{
+			"bare CR in header value",
+			"POST / HTTP/1.1\r\nX-Foo: bar\rContent-Length: 5\r\n\r\nhello",
+			headers.ErrMalformedHeader,
+		},
+		{
+			"bare LF request line",
+			"GET / HTTP/1.1\nHost: x\r\n\r\n",
+			ErrMalformedRequestLine,
+		},
+		{
+			"bare CR request line",
+			"GET /\r HTTP/1.1\r\nHost: x\r\n\r\n",
+			ErrMalformedRequestLine,
+		},
+		{
+			"NUL in header value",
+			"GET / HTTP/1.1\r\nX-Foo: a\x00b\r\n\r\n",
+			headers.ErrMalformedHeader,
+		},
+		{
+			"non ASCII header name",
+			"GET / HTTP/1.1\r\nTransfer-Encodíng: chunked\r\n\r\n",
+			headers.ErrInvalidHeaderName,
+		},
+		{
+			"chunk size with leading sign",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n+5\r\nhello\r\n0\r\n\r\n",
+			ErrMalformedChunk,
+		},
+		{
+			"chunk data longer than size",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhello\r\n0\r\n\r\n",
+			ErrMalformedChunk,
+		},
+		{
+			"chunk size line with bare LF",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\nhello\r\n0\r\n\r\n",
+			ErrMalformedChunk,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readFull(strings.NewReader(tt.data))
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestRepeatedContentLength(t *testing.T) {
+	r, body, err := readFull(strings.NewReader(
+		"POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.NotNil(t, r)
+
+	_, body, err = readFull(strings.NewReader(
+		"POST / HTTP/1.1\r\nContent-Length: 5, 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
@@ -0,0 +1,246 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TargetForm is one of the four request target forms of RFC 9112.
+type TargetForm int
+
+const (
+	// FormOrigin is an absolute path with an optional query, "/a/b?c=d".
+	FormOrigin TargetForm = iota
+	// FormAbsolute is a full URI, "http://example.com/a?b", sent to proxies.
+	FormAbsolute
+	// FormAuthority is "host:port", only used by CONNECT.
+	FormAuthority
+	// FormAsterisk is "*", only used by OPTIONS for the server as a whole.
+	FormAsterisk
+)
+
+// URL is the parsed request target.
+type URL struct {
+	Form TargetForm
+	// Scheme and Host are set for the absolute form, Host alone for the
+	// authority form.
+	Scheme string
+	Host   string
+	// Path is the percent-decoded path with dot-segments removed. RawPath
+	// is the same path still escaped as the client sent it.
+	Path    string
+	RawPath string
+	// Segments holds the decoded path segments, so a segment containing an
+	// escaped "/" is kept whole. "/a/b/" has the segments "a", "b" and "".
+	Segments []string
+	RawQuery string
+}
+
+// Query parses RawQuery on every call. Pairs with a malformed escape are
+// skipped.
+func (u *URL) Query() Values {
+	return ParseQuery(u.RawQuery)
+}
+
+// String returns the target as it would appear in a request line.
+func (u *URL) String() string {
+	switch u.Form {
+	case FormAsterisk:
+		return "*"
+	case FormAuthority:
+		return u.Host
+	}
+	s := u.RawPath
+	if u.RawQuery != "" {
+		s += "?" + u.RawQuery
+	}
+	if u.Form == FormAbsolute {
+		s = u.Scheme + "://" + u.Host + s
+	}
+	return s
+}
+
+// Values maps query parameter names to their values in order.
+type Values map[string][]string
+
+// Get returns the first value of key, or "".
+func (v Values) Get(key string) string {
+	if vals := v[key]; len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+func (v Values) Has(key string) bool {
+	_, ok := v[key]
+	return ok
+}
+
+// ParseQuery decodes an application/x-www-form-urlencoded string, where
+// "+" stands for a space.
+func ParseQuery(query string) Values {
+	values := make(Values)
+	for query != "" {
+		var pair string
+		pair, query, _ = strings.Cut(query, "&")
+		if pair == "" {
+			continue
+		}
+		key, val, _ := strings.Cut(pair, "=")
+		key, err := unescape(strings.ReplaceAll(key, "+", " "))
+		if err != nil {
+			continue
+		}
+		val, err = unescape(strings.ReplaceAll(val, "+", " "))
+		if err != nil {
+			continue
+		}
+		values[key] = append(values[key], val)
+	}
+	return values
+}
+
+// parseTarget parses the request target of method into a URL. The target
+// form must be the one the method calls for.
+func parseTarget(method, target string) (*URL, error) {
+	if !isTargetChars(target) {
+		return nil, fmt.Errorf("%w: target %q", ErrMalformedRequestLine, target)
+	}
+
+	switch {
+	case method == "CONNECT":
+		if !isAuthority(target) {
+			return nil, fmt.Errorf("%w: connect target %q", ErrMalformedRequestLine, target)
+		}
+		return &URL{Form: FormAuthority, Host: target}, nil
+
+	case target == "*":
+		if method != "OPTIONS" {
+			return nil, fmt.Errorf("%w: %s target *", ErrMalformedRequestLine, method)
+		}
+		return &URL{Form: FormAsterisk, Path: "*", RawPath: "*"}, nil
+
+	case strings.HasPrefix(target, "/"):
+		u := &URL{Form: FormOrigin}
+		return u, u.setPath(target)
+	}
+
+	scheme, rest, found := strings.Cut(target, "://")
+	scheme = strings.ToLower(scheme)
+	if !found || (scheme != "http" && scheme != "https") {
+		return nil, fmt.Errorf("%w: target %q", ErrMalformedRequestLine, target)
+	}
+	host, path := rest, "/"
+	if i := strings.IndexAny(rest, "/?"); i != -1 {
+		host, path = rest[:i], rest[i:]
+		if path[0] == '?' {
+			path = "/" + path
+		}
+	}
+	if host == "" || strings.Contains(host, "@") {
+		return nil, fmt.Errorf("%w: target host %q", ErrMalformedRequestLine, host)
+	}
+	u := &URL{Form: FormAbsolute, Scheme: scheme, Host: host}
+	return u, u.setPath(path)
+}
+
+// setPath splits target into path and query, decodes the path and removes
+// its dot-segments. Escaped dots count as dots, so "%2e%2e" cannot be used
+// to climb above the root.
+func (u *URL) setPath(target string) error {
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	u.RawQuery = rawQuery
+
+	type segment struct{ raw, decoded string }
+	var out []segment
+	rawSegments := strings.Split(rawPath[1:], "/")
+	for i, raw := range rawSegments {
+		decoded, err := unescape(raw)
+		if err != nil {
+			return fmt.Errorf("%w: target %q", ErrMalformedRequestLine, target)
+		}
+		switch decoded {
+		case ".":
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, segment{raw, decoded})
+			continue
+		}
+		// a trailing dot-segment leaves its directory with a trailing slash
+		if i == len(rawSegments)-1 {
+			out = append(out, segment{})
+		}
+	}
+
+	var path, escaped strings.Builder
+	u.Segments = make([]string, 0, len(out))
+	for _, s := range out {
+		path.WriteString("/" + s.decoded)
+		escaped.WriteString("/" + s.raw)
+		u.Segments = append(u.Segments, s.decoded)
+	}
+	u.Path, u.RawPath = path.String(), escaped.String()
+	return nil
+}
+
+// unescape decodes %XX escapes. A "%" not followed by two hex digits is an
+// error.
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			return "", fmt.Errorf("invalid escape %q", s[i:min(i+3, len(s))])
+		}
+		b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	}
+	return c - 'a' + 10
+}
+
+// isTargetChars reports whether s is printable ASCII without the characters
+// a URI never contains unescaped. A fragment is never sent in a request.
+func isTargetChars(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("#\"<>\\^`{|}", c) != -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// isAuthority reports whether s is "host:port" with a numeric port.
+func isAuthority(s string) bool {
+	i := strings.LastIndexByte(s, ':')
+	if i < 1 || !isDigits(s[i+1:]) {
+		return false
+	}
+	return !strings.ContainsAny(s[:i], "/?@")
+}
@@ -0,0 +1,77 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		method   string
+		target   string
+		form     TargetForm
+		host     string
+		path     string
+		rawPath  string
+		segments []string
+		query    string
+	}{
+		{"GET", "/", FormOrigin, "", "/", "/", []string{""}, ""},
+		{"GET", "/a/b?x=1&y=2", FormOrigin, "", "/a/b", "/a/b", []string{"a", "b"}, "x=1&y=2"},
+		{"GET", "/a/./b/../c", FormOrigin, "", "/a/c", "/a/c", []string{"a", "c"}, ""},
+		{"GET", "/a/b/..", FormOrigin, "", "/a/", "/a/", []string{"a", ""}, ""},
+		{"GET", "/../../etc", FormOrigin, "", "/etc", "/etc", []string{"etc"}, ""},
+		{"GET", "/a/%2e%2E/b", FormOrigin, "", "/b", "/b", []string{"b"}, ""},
+		{"GET", "/files/a%2Fb/c%20d", FormOrigin, "", "/files/a/b/c d", "/files/a%2Fb/c%20d",
+			[]string{"files", "a/b", "c d"}, ""},
+		{"GET", "http://example.com", FormAbsolute, "example.com", "/", "/", []string{""}, ""},
+		{"GET", "HTTP://example.com:8080/x?q", FormAbsolute, "example.com:8080", "/x", "/x", []string{"x"}, "q"},
+		{"GET", "https://example.com?q=1", FormAbsolute, "example.com", "/", "/", []string{""}, "q=1"},
+		{"CONNECT", "example.com:443", FormAuthority, "example.com:443", "", "", nil, ""},
+		{"OPTIONS", "*", FormAsterisk, "", "*", "*", nil, ""},
+	}
+	for _, tt := range tests {
+		u, err := parseTarget(tt.method, tt.target)
+		require.NoError(t, err, tt.target)
+		assert.Equal(t, tt.form, u.Form, tt.target)
+		assert.Equal(t, tt.host, u.Host, tt.target)
+		assert.Equal(t, tt.path, u.Path, tt.target)
+		assert.Equal(t, tt.rawPath, u.RawPath, tt.target)
+		assert.Equal(t, tt.segments, u.Segments, tt.target)
+		assert.Equal(t, tt.query, u.RawQuery, tt.target)
+	}
+
+	bad := []struct{ method, target string }{
+		{"GET", "coffee"},
+		{"GET", "*"},
+		{"GET", "/a%zz"},
+		{"GET", "/a%2"},
+		{"GET", "/a#frag"},
+		{"GET", "/caf\xc3\xa9"},
+		{"GET", "ftp://example.com/"},
+		{"GET", "http:///x"},
+		{"GET", "http://user@example.com/"},
+		{"CONNECT", "/"},
+		{"CONNECT", "example.com"},
+	}
+	for _, tt := range bad {
+		_, err := parseTarget(tt.method, tt.target)
+		assert.ErrorIs(t, err, ErrMalformedRequestLine, tt.target)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	u, err := parseTarget("GET", "/search?q=go+http&tag=a&tag=b%26c&empty=&bad=%zz&flag")
+	require.NoError(t, err)
+
+	q := u.Query()
+	assert.Equal(t, "go http", q.Get("q"))
+	assert.Equal(t, []string{"a", "b&c"}, q["tag"])
+	assert.True(t, q.Has("empty"))
+	assert.Equal(t, "", q.Get("empty"))
+	assert.True(t, q.Has("flag"))
+	assert.False(t, q.Has("bad"))
+	assert.Equal(t, "/search?q=go+http&tag=a&tag=b%26c&empty=&bad=%zz&flag", u.String())
+}
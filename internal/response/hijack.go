@@ -0,0 +1,61 @@
+package response
+
+import (
+	"errors"
+	"net"
+)
+
+var (
+	ErrNotHijackable = errors.New("connection cannot be hijacked")
+	ErrHijacked      = errors.New("connection hijacked")
+)
+
+// SetHijacker sets how Hijack gets hold of the connection. f returns it with
+// the bytes already read from it that no request consumed. The server sets
+// it; a Writer without one cannot be hijacked.
+func (w *Writer) SetHijacker(f func() (net.Conn, []byte, error)) {
+	w.hijacker = f
+}
+
+// Hijack hands the connection over to the handler, for protocols that take
+// over the socket such as WebSocket or CONNECT tunnels. The status line and
+// headers written so far, e.g. a 101 Switching Protocols, are sent first,
+// along with any body held back, unframed. The bytes returned were sent by
+// the client after the request head and must be read before the
+// connection.
+//
+// The server neither closes nor reuses a hijacked connection, and every
+// later call on the Writer fails with ErrHijacked.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.hijacker == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	if err := w.usable(); err != nil {
+		return nil, nil, err
+	}
+	if w.state != StatusWriteStatusLine {
+		if err := w.writeHeadersOnce(); err != nil {
+			return nil, nil, err
+		}
+		if err := w.commit(); err != nil {
+			return nil, nil, err
+		}
+		if !w.Head && len(w.buf) > 0 {
+			if err := w.write(w.buf); err != nil {
+				return nil, nil, err
+			}
+		}
+		w.buf = nil
+	}
+	if err := w.flush(); err != nil {
+		return nil, nil, err
+	}
+
+	conn, buffered, err := w.hijacker()
+	if err != nil {
+		return nil, nil, w.fail(err)
+	}
+	w.state = StatusWriteHijacked
+	w.KeepAlive = false
+	return conn, buffered, nil
+}
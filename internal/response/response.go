@@ -1,39 +1,26 @@
 package response
 
 import (
-	"bytes"
-	"errors"
 	"io"
-	"net"
 	"strconv"
+	"strings"
 	"tcpTohttp/internal/headers"
 )
 
-type StatusCode int
-
 const CRLF = "\r\n"
-const (
-	StatusOK            StatusCode = 200
-	BadRequest          StatusCode = 400
-	InternalServerError StatusCode = 500
-)
 
 func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
-	var statusPhrase string
-	httpVersion := "HTTP/1.1"
+	return writeStatusLine(w, "1.1", statusCode)
+}
 
-	switch statusCode {
-	case StatusOK:
-		statusPhrase = httpVersion + " 200 OK"
-	case BadRequest:
-		statusPhrase = httpVersion + " 400 Bad Request"
-	case InternalServerError:
-		statusPhrase = httpVersion + " 500 Internal Server Error"
-	default:
-		statusPhrase = httpVersion + " " + strconv.Itoa(int(statusCode)) + " "
+func writeStatusLine(w io.Writer, version string, statusCode StatusCode) error {
+	if err := checkStatusCode(statusCode); err != nil {
+		return err
 	}
+	statusLine := "HTTP/" + version + " " + strconv.Itoa(int(statusCode)) +
+		" " + StatusText(statusCode)
 
-	_, err := w.Write([]byte(statusPhrase + CRLF))
+	_, err := w.Write([]byte(statusLine + CRLF))
 
 	return err
 }
@@ -46,125 +33,45 @@ func GetDefaultHeaders(contentLen int) headers.Headers {
 	return *headers
 }
 
+// WriteHeaders writes the header section. Nothing is written if a field
+// could not be sent as is, see headers.CheckField.
 func WriteHeaders(w io.Writer, headers headers.Headers) error {
-	var data string
-	for key, val := range headers.Headers {
-		data += key + ": " + val + CRLF
-	}
-	data += CRLF
-	_, err := w.Write([]byte(data))
-
-	return err
-}
-
-type Writer struct {
-	Conn   net.Conn
-	Status StatusWrite
-}
-type StatusWrite int
-
-const (
-	StatusWriteStatusLine StatusWrite = iota
-	StatusWriteHeaders
-	StatusWriteBody
-)
-
-func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	if w.Status != StatusWriteStatusLine {
-		return errors.New("alwady writen status line")
-	}
-
-	buff := bytes.NewBuffer([]byte{})
-	err := WriteStatusLine(buff, statusCode)
-	if err != nil {
-		return err
-	}
-	w.Conn.Write(buff.Bytes())
-	w.Status = StatusWriteHeaders
-	return nil
-}
-
-func (w *Writer) WriteHeaders(headers *headers.Headers,
-	delHeaders []string, trailers *headers.Headers) error {
-
-	if w.Status != StatusWriteHeaders {
-		return errors.New("alwady writen Headers")
-	}
-	buff := bytes.NewBuffer([]byte{})
-	defHeaders := GetDefaultHeaders(0)
-
-	if headers != nil {
-		for key, val := range headers.Headers {
-			defHeaders.Replace(key, val)
-		}
-	}
-
-	for _, key := range delHeaders {
-		defHeaders.Delete(key)
-	}
-
-	if trailers != nil {
-		for key := range trailers.Headers {
-			defHeaders.Set("Trailer", key)
-		}
-	}
-
-	err := WriteHeaders(buff, defHeaders)
-	if err != nil {
+	if err := checkFields(&headers); err != nil {
 		return err
 	}
-	w.Conn.Write(buff.Bytes())
-	w.Status = StatusWriteBody
-	return nil
+	var data strings.Builder
+	headers.Range(func(key, val string) bool {
+		data.WriteString(key + ": " + val + CRLF)
+		return true
+	})
+	data.WriteString(CRLF)
+	_, err := w.Write([]byte(data.String()))
 
-}
-func (w *Writer) WriteBody(p []byte) (int, error) {
-	if w.Status != StatusWriteBody {
-		return 0, errors.New("alwady writen status line")
-	}
-	return w.Conn.Write(p)
+	return err
 }
 
-func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	lengthLine := strconv.FormatInt(int64(len(p)), 16) + CRLF
-
-	var buf bytes.Buffer
-	buf.WriteString(lengthLine)
-	buf.Write(p)
-	buf.WriteString(CRLF)
-
-	data := buf.Bytes()
-	read := 0
-	for read < len(data) {
-		n, err := w.Conn.Write(data[read:])
-		if err != nil {
-			return 0, err
-		}
-		read += n
-	}
-	return len(p), nil
+// checkFields returns the error of the first field that cannot be sent.
+func checkFields(h *headers.Headers) error {
+	var err error
+	h.Range(func(key, val string) bool {
+		err = headers.CheckField(key, val)
+		return err == nil
+	})
+	return err
 }
-func (w *Writer) WriteChunkedBodyDone() (int, error) {
 
-	data := []byte("0" + CRLF)
-	read := 0
-	for read < len(data) {
-		n, err := w.Conn.Write(data[read:])
-		if err != nil {
-			return 0, err
+// override replaces the fields of dst that src also has with all of src's
+// fields of that name.
+func override(dst, src *headers.Headers) {
+	seen := make(map[string]bool)
+	src.Range(func(key, val string) bool {
+		lower := strings.ToLower(key)
+		if seen[lower] {
+			dst.Add(key, val)
+		} else {
+			dst.Set(key, val)
+			seen[lower] = true
 		}
-		read += n
-	}
-	return read, nil
-
-}
-func (w *Writer) WriteTrailers(h *headers.Headers) error {
-	for key, val := range h.Headers {
-		_, err := w.Conn.Write([]byte(key + ":" + val + CRLF))
-		if err != nil {
-			return err
-		}
-	}
-	_, err := w.Conn.Write([]byte(CRLF))
-	return err
+		return true
+	})
 }
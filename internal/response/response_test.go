@@ -0,0 +1,421 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcpTohttp/internal/headers"
+)
+
+// testOutput records what is written to it, failing once err is set.
+type testOutput struct {
+	out    bytes.Buffer
+	writes int
+	err    error
+}
+
+func (o *testOutput) Write(p []byte) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+	o.writes++
+	return o.out.Write(p)
+}
+
+func TestWriterFraming(t *testing.T) {
+	// a small body gets its Content-Length worked out
+	conn := &testOutput{}
+	w := NewWriter(conn, true)
+	w.Write([]byte("hello "))
+	w.Write([]byte("world"))
+	assert.Zero(t, conn.out.Len())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Connection: keep-alive\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Content-Length: 11\r\n"+
+		"\r\n"+
+		"hello world", conn.out.String())
+
+	// a body past the buffer is sent chunked
+	conn = &testOutput{}
+	w = NewWriter(conn, true)
+	big := strings.Repeat("a", BufferSize+1)
+	w.Write([]byte(big))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, conn.out.String(), "Transfer-Encoding: chunked\r\n")
+	assert.NotContains(t, conn.out.String(), "Content-Length")
+	assert.True(t, strings.HasSuffix(conn.out.String(), "\r\n1001\r\n"+big+"\r\n0\r\n\r\n"))
+
+	// so is a flushed one
+	conn = &testOutput{}
+	w = NewWriter(conn, true)
+	w.Write([]byte("a"))
+	require.NoError(t, w.Flush())
+	assert.Contains(t, conn.out.String(), "Transfer-Encoding: chunked\r\n")
+	w.Write([]byte("bc"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(conn.out.String(), "\r\n\r\n1\r\na\r\n2\r\nbc\r\n0\r\n\r\n"))
+
+	// HTTP/1.0 gets a close-delimited body instead
+	conn = &testOutput{}
+	w = NewWriter(conn, true)
+	w.Version = "1.0"
+	w.Write([]byte("a"))
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive)
+	assert.Equal(t, "HTTP/1.0 200 OK\r\n"+
+		"Connection: close\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"\r\n"+
+		"a", conn.out.String())
+
+	// HEAD gets the length of the body it does not get
+	conn = &testOutput{}
+	w = NewWriter(conn, false)
+	w.Head = true
+	w.Write([]byte("hello"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Connection: close\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Content-Length: 5\r\n"+
+		"\r\n", conn.out.String())
+
+	conn = &testOutput{}
+	w = NewWriter(conn, true)
+	require.NoError(t, w.NoContent())
+	assert.Equal(t, BodyNone, w.BodyMode())
+	_, err := w.Write([]byte("x"))
+	assert.ErrorIs(t, err, ErrBodyNotAllowed)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n"+
+		"Connection: keep-alive\r\n"+
+		"\r\n", conn.out.String())
+}
+
+func TestWriterDeclaredLength(t *testing.T) {
+	h := headers.NewHeaders()
+	h.Set("Content-Length", "5")
+
+	conn := &testOutput{}
+	w := NewWriter(conn, true)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(h, nil, nil)
+	_, err := w.Write([]byte("hel"))
+	require.NoError(t, err)
+	assert.Zero(t, conn.out.Len())
+	// a declared length needs no holding back, only a flush
+	require.NoError(t, w.Flush())
+	assert.True(t, strings.HasSuffix(conn.out.String(), "\r\n\r\nhel"))
+	_, err = w.Write([]byte("lo!"))
+	assert.ErrorIs(t, err, ErrContentLength)
+	_, err = w.Write([]byte("lo"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive)
+
+	conn = &testOutput{}
+	w = NewWriter(conn, true)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(h, nil, nil)
+	w.Write([]byte("hel"))
+	assert.ErrorIs(t, w.Finish(), ErrContentLength)
+	assert.False(t, w.KeepAlive)
+}
+
+func TestWriterReset(t *testing.T) {
+	conn := &testOutput{}
+	w := NewWriter(conn, true)
+	w.Header().Set("X-Request-ID", "1")
+	w.WriteStatusLine(StatusOK)
+	w.Write([]byte("partial"))
+	require.NoError(t, w.Reset())
+	require.NoError(t, w.Error(StatusInternalServerError, ""))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, conn.out.String(), "HTTP/1.1 500 Internal Server Error\r\n")
+	assert.Contains(t, conn.out.String(), "X-Request-ID: 1\r\n")
+	assert.True(t, strings.HasSuffix(conn.out.String(), "\r\n\r\nInternal Server Error"))
+
+	w.Flush()
+	assert.ErrorIs(t, w.Reset(), ErrCommitted)
+}
+
+func TestWriterTrailers(t *testing.T) {
+	conn := &testOutput{}
+	w := NewWriter(conn, true)
+	require.NoError(t, w.DeclareTrailer("X-Checksum", "X-Count"))
+	w.Write([]byte("hello"))
+	require.NoError(t, w.SetTrailer("x-checksum", "abc"))
+	assert.ErrorIs(t, w.SetTrailer("X-Other", "1"), ErrTrailerNotDeclared)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Connection: keep-alive\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"Trailer: X-Checksum, X-Count\r\n"+
+		"\r\n"+
+		"5\r\nhello\r\n"+
+		"0\r\n"+
+		"X-Checksum: abc\r\n"+
+		"\r\n", conn.out.String())
+
+	w = NewWriter(&testOutput{}, true)
+	assert.ErrorIs(t, w.DeclareTrailer("Content-Length"), ErrInvalidTrailer)
+	assert.ErrorIs(t, w.DeclareTrailer("Bad Name"), ErrInvalidTrailer)
+
+	// a declared length leaves no room for trailers
+	conn = &testOutput{}
+	w = NewWriter(conn, true)
+	h := headers.NewHeaders()
+	h.Set("Content-Length", "2")
+	w.DeclareTrailer("X-Checksum")
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(h, nil, nil)
+	w.Write([]byte("hi"))
+	w.SetTrailer("X-Checksum", "abc")
+	require.NoError(t, w.Finish())
+	assert.NotContains(t, conn.out.String(), "Trailer")
+	assert.True(t, strings.HasSuffix(conn.out.String(), "\r\n\r\nhi"))
+
+	// as does HTTP/1.0
+	conn = &testOutput{}
+	w = NewWriter(conn, false)
+	w.Version = "1.0"
+	w.DeclareTrailer("X-Checksum")
+	w.Write([]byte("hi"))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, conn.out.String(), "Content-Length: 2\r\n")
+	assert.NotContains(t, conn.out.String(), "Trailer")
+
+	assert.ErrorIs(t, w.DeclareTrailer("X-Late"), ErrCommitted)
+}
+
+func TestWriterStates(t *testing.T) {
+	conn := &testOutput{}
+	w := NewWriter(conn, true)
+	assert.ErrorIs(t, w.WriteHeaders(nil, nil, nil), ErrStatusNotWritten)
+	assert.ErrorIs(t, w.WriteStatusLine(StatusContinue), ErrInvalidStatusCode)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.Equal(t, StatusWriteHeaders, w.Status())
+	assert.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrStatusWritten)
+	assert.ErrorIs(t, w.WriteInformational(StatusEarlyHints, nil), ErrStatusWritten)
+
+	h := headers.NewHeaders()
+	h.Set("Content-Length", "2")
+	require.NoError(t, w.WriteHeaders(h, nil, nil))
+	assert.Equal(t, BodyFixed, w.BodyMode())
+	assert.ErrorIs(t, w.WriteHeaders(h, nil, nil), ErrHeadersWritten)
+	_, err := w.WriteChunkedBody([]byte("hi"))
+	assert.ErrorIs(t, err, ErrBodyMode)
+
+	w.Write([]byte("hi"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, StatusWriteDone, w.Status())
+	assert.True(t, w.Reusable())
+	_, err = w.Write([]byte("!"))
+	assert.ErrorIs(t, err, ErrFinished)
+
+	// a close-delimited body ends the connection
+	w = NewWriter(&testOutput{}, true)
+	w.Version = "1.0"
+	w.WriteChunkedBody([]byte("hi"))
+	assert.Equal(t, BodyCloseDelimited, w.BodyMode())
+	require.NoError(t, w.Finish())
+	assert.False(t, w.Reusable())
+
+	// so does an aborted one
+	w = NewWriter(&testOutput{}, true)
+	w.WriteChunkedBody([]byte("hi"))
+	assert.Equal(t, BodyChunked, w.BodyMode())
+	w.Abort()
+	assert.ErrorIs(t, w.Finish(), ErrAborted)
+	assert.Equal(t, StatusWriteFailed, w.Status())
+	assert.False(t, w.Reusable())
+}
+
+func TestWriterRecordsWriteErrors(t *testing.T) {
+	conn := &testOutput{}
+	w := NewWriter(conn, true)
+	w.Write([]byte("hi"))
+	require.NoError(t, w.Flush())
+
+	broken := errors.New("broken pipe")
+	conn.err = broken
+	_, err := w.Write([]byte("more"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.Flush(), broken)
+	assert.ErrorIs(t, w.Err(), broken)
+	assert.Equal(t, StatusWriteFailed, w.Status())
+
+	// every later call reports the same failure
+	conn.err = nil
+	_, err = w.Write([]byte("again"))
+	assert.ErrorIs(t, err, broken)
+	assert.ErrorIs(t, w.Finish(), broken)
+	assert.False(t, w.Reusable())
+	assert.NotContains(t, conn.out.String(), "again")
+}
+
+func TestWriterCoalescesWrites(t *testing.T) {
+	conn := &testOutput{}
+	w := NewWriter(conn, true)
+	h := headers.NewHeaders()
+	h.Set("Content-Length", "11")
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(h, nil, nil)
+	w.Write([]byte("hello "))
+	w.Write([]byte("world"))
+	require.NoError(t, w.Finish())
+	// status line, headers and body go out together
+	assert.Equal(t, 1, conn.writes)
+	assert.True(t, strings.HasSuffix(conn.out.String(), "\r\n\r\nhello world"))
+
+	// interim responses are not held back
+	conn = &testOutput{}
+	w = NewWriter(conn, true)
+	require.NoError(t, w.WriteInformational(StatusEarlyHints, nil))
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\n\r\n", conn.out.String())
+	w.Release()
+
+	// any io.Writer will do
+	var out bytes.Buffer
+	w = NewWriter(&out, false)
+	w.Write([]byte("hi"))
+	require.NoError(t, w.Finish())
+	w.Release()
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\nhi"))
+	_, err := w.Write([]byte("more"))
+	assert.Error(t, err)
+}
+
+func TestWriterHijack(t *testing.T) {
+	_, _, err := NewWriter(&testOutput{}, true).Hijack()
+	assert.ErrorIs(t, err, ErrNotHijackable)
+
+	conn := &testOutput{}
+	w := NewWriter(conn, true)
+	w.SetHijacker(func() (net.Conn, []byte, error) {
+		// the response head is out before the connection changes hands
+		assert.Contains(t, conn.out.String(), "HTTP/1.1 101 Switching Protocols\r\n")
+		return nil, []byte("early"), nil
+	})
+	h := headers.NewHeaders()
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", "websocket")
+	w.WriteStatusLine(StatusSwitchingProtocols)
+	w.WriteHeaders(h, nil, nil)
+	_, buffered, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("early"), buffered)
+	assert.True(t, strings.HasSuffix(conn.out.String(), "Upgrade: websocket\r\n\r\n"))
+
+	assert.Equal(t, StatusWriteHijacked, w.Status())
+	assert.False(t, w.Reusable())
+	_, err = w.Write([]byte("x"))
+	assert.ErrorIs(t, err, ErrHijacked)
+	assert.ErrorIs(t, w.Reset(), ErrHijacked)
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ErrHijacked)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(conn.out.String(), "websocket\r\n\r\n"))
+}
+
+func TestHeaderInjection(t *testing.T) {
+	conn := &testOutput{}
+	w := NewWriter(conn, true)
+	err := w.Redirect(StatusFound, "/x\r\nSet-Cookie: pwn=1")
+	assert.ErrorIs(t, err, headers.ErrInvalidHeaderValue)
+	require.NoError(t, w.Redirect(StatusFound, "/x"))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, conn.out.String(), "Location: /x\r\n")
+	assert.NotContains(t, conn.out.String(), "pwn")
+
+	w = NewWriter(&testOutput{}, true)
+	w.Header().Set("X-Echo", "a\nb")
+	w.WriteStatusLine(StatusOK)
+	assert.ErrorIs(t, w.WriteHeaders(nil, nil, nil), headers.ErrInvalidHeaderValue)
+	w.Header().Del("X-Echo")
+	h := headers.NewHeaders()
+	h.Set("Bad Name", "v")
+	assert.ErrorIs(t, w.WriteHeaders(h, nil, nil), headers.ErrInvalidHeaderName)
+
+	w = NewWriter(&testOutput{}, true)
+	require.NoError(t, w.DeclareTrailer("X-Sum"))
+	assert.ErrorIs(t, w.SetTrailer("X-Sum", "1\r\n\r\nsmuggled"), headers.ErrInvalidHeaderValue)
+
+	var out bytes.Buffer
+	h = headers.NewHeaders()
+	h.Set("X-Echo", "a\r\nb")
+	assert.ErrorIs(t, WriteHeaders(&out, *h), headers.ErrInvalidHeaderValue)
+	assert.Zero(t, out.Len())
+}
+
+func TestWriteInformational(t *testing.T) {
+	conn := &testOutput{}
+	w := NewWriter(conn, true)
+	for _, link := range []string{"</a.css>; rel=preload", "</b.js>; rel=preload"} {
+		h := headers.NewHeaders()
+		h.Set("Link", link)
+		require.NoError(t, w.WriteInformational(StatusEarlyHints, h))
+	}
+	// each one goes out on its own, ahead of the final response
+	hints := "HTTP/1.1 103 Early Hints\r\nLink: </a.css>; rel=preload\r\n\r\n" +
+		"HTTP/1.1 103 Early Hints\r\nLink: </b.js>; rel=preload\r\n\r\n"
+	assert.Equal(t, hints, conn.out.String())
+	w.Write([]byte("hi"))
+	require.NoError(t, w.Finish())
+	final := strings.TrimPrefix(conn.out.String(), hints)
+	assert.True(t, strings.HasPrefix(final, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(final, "\r\n\r\nhi"))
+
+	w = NewWriter(&testOutput{}, true)
+	assert.ErrorIs(t, w.WriteInformational(StatusSwitchingProtocols, nil), ErrInvalidStatusCode)
+	assert.ErrorIs(t, w.WriteInformational(StatusOK, nil), ErrInvalidStatusCode)
+	assert.Equal(t, StatusWriteStatusLine, w.Status())
+
+	// HTTP/1.0 has no interim responses
+	conn = &testOutput{}
+	w = NewWriter(conn, true)
+	w.Version = "1.0"
+	require.NoError(t, w.WriteInformational(StatusEarlyHints, nil))
+	assert.Zero(t, conn.out.Len())
+	w.Write([]byte("hi"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(conn.out.String(), "HTTP/1.0 200 OK\r\n"))
+}
+
+func TestWriterStreamsLargeBody(t *testing.T) {
+	held := bytes.Repeat([]byte("a"), 100)
+	big := bytes.Repeat([]byte("b"), 8<<20)
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	before := ms.TotalAlloc
+	w := NewWriter(io.Discard, true)
+	w.Write(held)
+	w.Write(big)
+	require.NoError(t, w.Finish())
+	runtime.ReadMemStats(&ms)
+	// the body goes out as it is, it is not copied
+	assert.Less(t, ms.TotalAlloc-before, uint64(1<<20))
+
+	// what was held back is a chunk of its own, ahead of the large one
+	conn := &testOutput{}
+	w = NewWriter(conn, true)
+	w.Write(held)
+	w.Write(big[:BufferSize])
+	require.NoError(t, w.Finish())
+	_, body, _ := strings.Cut(conn.out.String(), "\r\n\r\n")
+	assert.True(t, strings.HasPrefix(body, "64\r\n"+string(held)+"\r\n1000\r\nbbb"))
+	assert.True(t, strings.HasSuffix(body, "bbb\r\n0\r\n\r\n"))
+	assert.Len(t, body, 4+len(held)+2+6+BufferSize+2+5)
+}
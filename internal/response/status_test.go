@@ -0,0 +1,38 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusLine(t *testing.T) {
+	tests := []struct {
+		code StatusCode
+		line string
+	}{
+		{StatusOK, "HTTP/1.1 200 OK\r\n"},
+		{StatusEarlyHints, "HTTP/1.1 103 Early Hints\r\n"},
+		{StatusPermanentRedirect, "HTTP/1.1 308 Permanent Redirect\r\n"},
+		{StatusHeaderTooLarge, "HTTP/1.1 431 Request Header Fields Too Large\r\n"},
+		{StatusNetworkAuthenticationRequired, "HTTP/1.1 511 Network Authentication Required\r\n"},
+		// unregistered codes go out without a reason phrase
+		{299, "HTTP/1.1 299 \r\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		require.NoError(t, WriteStatusLine(&buf, tt.code))
+		assert.Equal(t, tt.line, buf.String())
+	}
+
+	for _, code := range []StatusCode{0, 99, 1000, -200} {
+		var buf bytes.Buffer
+		assert.ErrorIs(t, WriteStatusLine(&buf, code), ErrInvalidStatusCode, code)
+		assert.Zero(t, buf.Len())
+	}
+
+	assert.Equal(t, "Not Found", StatusText(StatusNotFound))
+	assert.Equal(t, "", StatusText(418))
+}
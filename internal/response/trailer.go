@@ -0,0 +1,83 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"tcpTohttp/internal/headers"
+)
+
+var (
+	ErrTrailerNotDeclared = errors.New("trailer not declared")
+	ErrInvalidTrailer     = errors.New("field not allowed as a trailer")
+)
+
+// forbiddenTrailers are fields a recipient needs before the body, for
+// framing, routing, authentication or to process the content, so they must
+// not be sent as trailers.
+var forbiddenTrailers = []string{
+	"age", "authorization", "cache-control", "content-encoding",
+	"content-length", "content-range", "content-type", "date", "expect",
+	"expires", "host", "location", "max-forwards", "pragma",
+	"proxy-authenticate", "proxy-authorization", "range", "retry-after",
+	"set-cookie", "te", "trailer", "transfer-encoding", "vary",
+	"www-authenticate",
+}
+
+// DeclareTrailer announces fields sent after the body, in the Trailer
+// header. Their values are set with SetTrailer while the body is written.
+// Trailers need a chunked body: a response sent with a Content-Length, to
+// an HTTP/1.0 client or to HEAD goes without them.
+func (w *Writer) DeclareTrailer(names ...string) error {
+	if w.committed {
+		return ErrCommitted
+	}
+	for _, name := range names {
+		if !headers.IsToken(name) || slices.Contains(forbiddenTrailers, strings.ToLower(name)) {
+			return fmt.Errorf("%w: %q", ErrInvalidTrailer, name)
+		}
+	}
+	for _, name := range names {
+		if !w.trailerDeclared(name) {
+			w.trailerNames = append(w.trailerNames, name)
+		}
+	}
+	return nil
+}
+
+// SetTrailer sets the value of a declared trailer. Only the last value set
+// before the handler returns is sent.
+func (w *Writer) SetTrailer(key, val string) error {
+	if !w.trailerDeclared(key) {
+		return fmt.Errorf("%w: %q", ErrTrailerNotDeclared, key)
+	}
+	if err := headers.CheckField(key, val); err != nil {
+		return err
+	}
+	w.trailer.Set(key, val)
+	return nil
+}
+
+func (w *Writer) trailerDeclared(name string) bool {
+	for _, declared := range w.trailerNames {
+		if strings.EqualFold(declared, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastChunk returns the end of a chunked body: the zero sized chunk, the
+// trailers that got a value and the final CRLF.
+func (w *Writer) lastChunk() []byte {
+	var buf strings.Builder
+	buf.WriteString("0" + CRLF)
+	for _, name := range w.trailerNames {
+		if values := w.trailer.Values(name); len(values) > 0 {
+			buf.WriteString(name + ": " + values[0] + CRLF)
+		}
+	}
+	buf.WriteString(CRLF)
+	return []byte(buf.String())
+}
@@ -0,0 +1,683 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"tcpTohttp/internal/headers"
+	"time"
+)
+
+// BufferSize is how much of a body the Writer holds back to work out its
+// Content-Length. A longer body, or one the handler flushes, is sent
+// chunked instead. It is also the size of the buffer writes to the
+// connection are gathered in.
+const BufferSize = 4 << 10
+
+var bufioPool = sync.Pool{
+	New: func() any { return bufio.NewWriterSize(nil, BufferSize) },
+}
+
+// Errors returned for misuse of a Writer. An error writing to the
+// connection is returned as is, and by every call after it; see Err.
+var (
+	ErrContentLength    = errors.New("body length does not match content-length")
+	ErrBodyNotAllowed   = errors.New("response status does not allow a body")
+	ErrBodyMode         = errors.New("write does not fit the body mode")
+	ErrStatusWritten    = errors.New("status line already written")
+	ErrStatusNotWritten = errors.New("status line not written")
+	ErrHeadersWritten   = errors.New("headers already written")
+	ErrCommitted        = errors.New("response already committed")
+	ErrFinished         = errors.New("response already finished")
+	ErrAborted          = errors.New("response aborted")
+)
+
+// StatusWrite is how far a Writer got with the response.
+type StatusWrite int
+
+const (
+	// nothing was written, interim responses may still be sent
+	StatusWriteStatusLine StatusWrite = iota
+	// the status is set, the headers come next
+	StatusWriteHeaders
+	// the headers are set, the body is being written
+	StatusWriteBody
+	// Finish completed the response
+	StatusWriteDone
+	// the response was given up on, by Abort or a failed write
+	StatusWriteFailed
+	// the handler took over the connection, see Hijack
+	StatusWriteHijacked
+)
+
+// BodyMode is how the end of the body is made known to the client.
+type BodyMode int
+
+const (
+	// BodyBuffered holds the body back until it ends, when its length is
+	// known, or until it outgrows BufferSize and is streamed
+	BodyBuffered BodyMode = iota
+	// BodyNone is the mode of statuses that never have a body
+	BodyNone
+	// BodyFixed sends exactly Content-Length bytes
+	BodyFixed
+	BodyChunked
+	// BodyCloseDelimited ends the body by closing the connection
+	BodyCloseDelimited
+)
+
+func (m BodyMode) String() string {
+	switch m {
+	case BodyBuffered:
+		return "buffered"
+	case BodyNone:
+		return "no"
+	case BodyFixed:
+		return "fixed length"
+	case BodyChunked:
+		return "chunked"
+	case BodyCloseDelimited:
+		return "close-delimited"
+	}
+	return "unknown"
+}
+
+// Writer writes one response. Everything goes through a buffer, so the
+// status line and headers reach the connection in one write with the first
+// body bytes; Flush pushes out what is buffered.
+type Writer struct {
+	// KeepAlive tells the client the connection stays open after this
+	// response. It is cleared if the handler sends "Connection: close".
+	KeepAlive bool
+	// Head drops every body byte, for responses to HEAD. Headers are sent
+	// unchanged, so Content-Length still tells the size a GET would get.
+	Head bool
+	// Version is the HTTP version of the request, "1.1" or "1.0", which
+	// the response is sent in. HTTP/1.0 clients never get a chunked body:
+	// it is sent unframed and delimited by closing the connection.
+	Version string
+
+	bw *bufio.Writer
+	// hijacker hands the connection over, see SetHijacker
+	hijacker func() (net.Conn, []byte, error)
+	state    StatusWrite
+	mode     BodyMode
+	// committed is set once the status line and headers went out
+	committed bool
+	// err is the first error that made the response unusable
+	err error
+
+	header *headers.Headers
+	// head is the header section built by WriteHeaders. It goes out with
+	// the first body bytes that are not held back.
+	head headers.Headers
+	// buf holds a BodyBuffered body
+	buf []byte
+	// declared is the length of a BodyFixed body
+	declared int64
+	// written counts body bytes the handler wrote, HEAD included
+	written int64
+	// trailerNames are the declared trailers, trailer their values
+	trailerNames []string
+	trailer      headers.Headers
+
+	statusCode   StatusCode
+	bytesWritten int64
+	start        time.Time
+}
+
+// NewWriter returns a Writer for a response sent to out. Its buffer comes
+// from a pool and goes back with Release.
+func NewWriter(out io.Writer, keepAlive bool) *Writer {
+	bw := bufioPool.Get().(*bufio.Writer)
+	bw.Reset(out)
+	return &Writer{
+		bw:        bw,
+		KeepAlive: keepAlive,
+		Version:   "1.1",
+		start:     time.Now(),
+	}
+}
+
+// Release returns the buffer of the Writer to the pool. What it still holds
+// is dropped, so it comes after Finish; the Writer cannot be used anymore.
+func (w *Writer) Release() {
+	if w.bw == nil {
+		return
+	}
+	w.bw.Reset(nil)
+	bufioPool.Put(w.bw)
+	w.bw = nil
+}
+
+// Header returns headers sent along with the ones passed to WriteHeaders,
+// which take precedence. Middleware uses it to add response headers before
+// the handler runs.
+func (w *Writer) Header() *headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
+	}
+	return w.header
+}
+
+// StatusCode returns the status code written, or 0 if none was.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten returns the number of body bytes written, not counting
+// chunk framing or bytes dropped for HEAD.
+func (w *Writer) BytesWritten() int64 {
+	return w.bytesWritten
+}
+
+// Duration returns the time since the writer was created.
+func (w *Writer) Duration() time.Duration {
+	return time.Since(w.start)
+}
+
+func (w *Writer) Status() StatusWrite {
+	return w.state
+}
+
+func (w *Writer) BodyMode() BodyMode {
+	return w.mode
+}
+
+// Err returns the error that made the response fail, either writing to
+// the connection or ErrAborted.
+func (w *Writer) Err() error {
+	return w.err
+}
+
+// Committed reports whether the status line and headers went out, after
+// which the response can no longer be replaced by another one.
+func (w *Writer) Committed() bool {
+	return w.committed
+}
+
+// Reusable reports whether the connection can carry another response once
+// this one is finished: nothing failed, the client knows where the body
+// ended and neither side asked to close.
+func (w *Writer) Reusable() bool {
+	return w.state == StatusWriteDone && w.err == nil && w.KeepAlive &&
+		w.mode != BodyCloseDelimited
+}
+
+// Reset drops the status, headers and body held back so far, so another
+// response can be written instead. It fails once the response is
+// committed.
+func (w *Writer) Reset() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.state == StatusWriteHijacked {
+		return ErrHijacked
+	}
+	if w.committed {
+		return ErrCommitted
+	}
+	*w = Writer{
+		bw:        w.bw,
+		hijacker:  w.hijacker,
+		KeepAlive: w.KeepAlive,
+		Head:      w.Head,
+		Version:   w.Version,
+		header:    w.header,
+		start:     w.start,
+	}
+	return nil
+}
+
+// Abort gives up on the response. Nothing more is sent, and Finish leaves
+// a committed body unterminated so the client can tell it was cut short.
+func (w *Writer) Abort() {
+	w.fail(ErrAborted)
+}
+
+// fail records err as the reason the response failed and returns it.
+func (w *Writer) fail(err error) error {
+	if w.err == nil {
+		w.err = err
+	}
+	w.state = StatusWriteFailed
+	w.KeepAlive = false
+	return w.err
+}
+
+// usable returns the error any write must fail with now, if any.
+func (w *Writer) usable() error {
+	if w.err != nil {
+		return w.err
+	}
+	switch w.state {
+	case StatusWriteDone:
+		return ErrFinished
+	case StatusWriteHijacked:
+		return ErrHijacked
+	}
+	return nil
+}
+
+// write buffers p for the connection, recording a failure.
+func (w *Writer) write(p []byte) error {
+	if w.bw == nil {
+		return w.fail(ErrFinished)
+	}
+	if _, err := w.bw.Write(p); err != nil {
+		return w.fail(err)
+	}
+	return nil
+}
+
+// flush writes out the buffer, recording a failure.
+func (w *Writer) flush() error {
+	if w.bw == nil {
+		return w.fail(ErrFinished)
+	}
+	if err := w.bw.Flush(); err != nil {
+		return w.fail(err)
+	}
+	return nil
+}
+
+// WriteStatusLine sets the status of the response. It is sent along with
+// the headers.
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	if err := w.usable(); err != nil {
+		return err
+	}
+	if w.state != StatusWriteStatusLine {
+		return ErrStatusWritten
+	}
+	if err := checkStatusCode(statusCode); err != nil {
+		return err
+	}
+	if statusCode < 200 && statusCode != StatusSwitchingProtocols {
+		return fmt.Errorf("%w: %d is interim, see WriteInformational", ErrInvalidStatusCode, statusCode)
+	}
+	w.state = StatusWriteHeaders
+	w.statusCode = statusCode
+	return nil
+}
+
+// WriteInformational sends a 1xx interim response with h as its only
+// headers. Any number of them may precede the final status line, e.g. 103
+// Early Hints with Link headers. 101 is a final answer of its own and goes
+// through WriteStatusLine. HTTP/1.0 clients do not understand 1xx
+// responses, so nothing is sent to them.
+func (w *Writer) WriteInformational(statusCode StatusCode, h *headers.Headers) error {
+	if err := w.usable(); err != nil {
+		return err
+	}
+	if w.state != StatusWriteStatusLine {
+		return ErrStatusWritten
+	}
+	if statusCode < 100 || statusCode > 199 || statusCode == StatusSwitchingProtocols {
+		return fmt.Errorf("%w: %d is not an interim response", ErrInvalidStatusCode, statusCode)
+	}
+	if w.Version == "1.0" {
+		return nil
+	}
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+	if err := checkFields(h); err != nil {
+		return err
+	}
+
+	// interim responses are meant to be seen right away
+	writeStatusLine(w.bw, w.Version, statusCode)
+	WriteHeaders(w.bw, *h)
+	return w.flush()
+}
+
+// WriteHeaders sets the response headers and with them the body mode.
+// Content-Length is worked out by the Writer unless the handler sets it,
+// in which case the body must be exactly that long. Setting
+// "Transfer-Encoding: chunked" streams the body right away. The fields in
+// trailers are declared as trailers along with their current values, see
+// DeclareTrailer.
+func (w *Writer) WriteHeaders(headers *headers.Headers,
+	delHeaders []string, trailers *headers.Headers) error {
+
+	if err := w.usable(); err != nil {
+		return err
+	}
+	switch w.state {
+	case StatusWriteStatusLine:
+		return ErrStatusNotWritten
+	case StatusWriteBody:
+		return ErrHeadersWritten
+	}
+	defHeaders := GetDefaultHeaders(0)
+	defHeaders.Del("Content-Length")
+	if w.KeepAlive {
+		defHeaders.Set("Connection", "keep-alive")
+	}
+
+	if w.header != nil {
+		override(&defHeaders, w.header)
+	}
+	if headers != nil {
+		override(&defHeaders, headers)
+	}
+
+	for _, key := range delHeaders {
+		defHeaders.Del(key)
+	}
+	if err := checkFields(&defHeaders); err != nil {
+		return err
+	}
+	if trailers != nil {
+		if err := checkFields(trailers); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case !bodyAllowed(w.statusCode):
+		w.mode = BodyNone
+	case strings.EqualFold(defHeaders.Get("Transfer-Encoding"), "chunked"):
+		// a length next to chunked framing would be ignored at best
+		defHeaders.Del("Content-Length")
+		w.mode = BodyChunked
+		if w.Version == "1.0" {
+			defHeaders.Del("Transfer-Encoding")
+			defHeaders.Set("Connection", "close")
+			w.mode = BodyCloseDelimited
+		}
+	case defHeaders.Has("Content-Length"):
+		declared, err := strconv.ParseInt(defHeaders.Get("Content-Length"), 10, 64)
+		if err != nil || declared < 0 {
+			return fmt.Errorf("%w: %q", ErrContentLength, defHeaders.Get("Content-Length"))
+		}
+		w.mode, w.declared = BodyFixed, declared
+	default:
+		w.mode = BodyBuffered
+	}
+
+	if trailers != nil {
+		var names []string
+		trailers.Range(func(key, val string) bool {
+			names = append(names, key)
+			return true
+		})
+		if err := w.DeclareTrailer(names...); err != nil {
+			return err
+		}
+		trailers.Range(func(key, val string) bool {
+			w.trailer.Set(key, val)
+			return true
+		})
+	}
+
+	if strings.EqualFold(defHeaders.Get("Connection"), "close") {
+		w.KeepAlive = false
+	}
+
+	w.head = defHeaders
+	w.state = StatusWriteBody
+	return nil
+}
+
+// Write writes body bytes, sending a 200 status and the default headers
+// first if the handler did not set them.
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteBody(p)
+}
+
+func (w *Writer) WriteBody(p []byte) (int, error) {
+	if err := w.writeHeadersOnce(); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	switch w.mode {
+	case BodyNone:
+		return 0, fmt.Errorf("%w: %d", ErrBodyNotAllowed, w.statusCode)
+	case BodyFixed:
+		if w.written+int64(len(p)) > w.declared {
+			return 0, fmt.Errorf("%w: writing past %d bytes", ErrContentLength, w.declared)
+		}
+	}
+	w.written += int64(len(p))
+	if !w.Head {
+		w.bytesWritten += int64(len(p))
+	}
+
+	if w.mode == BodyBuffered {
+		if w.written <= BufferSize {
+			if !w.Head {
+				w.buf = append(w.buf, p...)
+			}
+			return len(p), nil
+		}
+		// the body outgrew the buffer: what was held back goes first, p
+		// follows as it is
+		w.stream()
+		if err := w.sendBuffered(); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := w.commit(); err != nil {
+		return 0, err
+	}
+	if err := w.send(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteChunkedBody writes p and sends it right away as one chunk. It fails
+// with ErrBodyMode if the handler set a Content-Length.
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if err := w.writeHeadersOnce(); err != nil {
+		return 0, err
+	}
+	switch w.mode {
+	case BodyBuffered, BodyChunked, BodyCloseDelimited:
+	case BodyNone:
+		return 0, fmt.Errorf("%w: %d", ErrBodyNotAllowed, w.statusCode)
+	default:
+		return 0, fmt.Errorf("%w: chunk written to a %s body", ErrBodyMode, w.mode)
+	}
+	n, err := w.WriteBody(p)
+	if err != nil {
+		return n, err
+	}
+	return n, w.Flush()
+}
+
+// Flush sends what the Writer holds so far to the connection. A body of
+// unknown length is sent chunked from then on.
+func (w *Writer) Flush() error {
+	if err := w.writeHeadersOnce(); err != nil {
+		return err
+	}
+	if w.mode == BodyBuffered {
+		w.stream()
+	}
+	if err := w.sendBuffered(); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// sendBuffered moves the headers and held back body into the buffer.
+func (w *Writer) sendBuffered() error {
+	if err := w.commit(); err != nil {
+		return err
+	}
+	buf := w.buf
+	w.buf = nil
+	return w.send(buf)
+}
+
+// Finish completes the response once the handler returned: a body held
+// back so far is sent with its Content-Length and a chunked body gets its
+// last chunk and trailers. A handler that wrote nothing gets an empty 200.
+// It returns ErrContentLength if the body was shorter than declared, and
+// the error the response failed with if it did; the connection must not be
+// reused then.
+func (w *Writer) Finish() error {
+	if w.err != nil {
+		return w.err
+	}
+	switch w.state {
+	case StatusWriteDone, StatusWriteHijacked:
+		return nil
+	}
+	if err := w.writeHeadersOnce(); err != nil {
+		return err
+	}
+
+	// trailers are worth a chunked body, which stream switches to
+	if w.mode == BodyBuffered &&
+		(len(w.trailerNames) == 0 || w.Version == "1.0" || w.Head) {
+		w.head.Set("Content-Length", strconv.FormatInt(w.written, 10))
+		w.mode, w.declared = BodyFixed, w.written
+	}
+	if w.mode == BodyBuffered {
+		w.stream()
+	}
+	if err := w.sendBuffered(); err != nil {
+		return err
+	}
+
+	if w.mode == BodyChunked && !w.Head {
+		if err := w.write(w.lastChunk()); err != nil {
+			return err
+		}
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	if w.mode == BodyFixed && w.written < w.declared && !w.Head {
+		return w.fail(fmt.Errorf("%w: %d of %d bytes written", ErrContentLength, w.written, w.declared))
+	}
+	w.state = StatusWriteDone
+	return nil
+}
+
+// writeHeadersOnce fills in what the handler skipped before writing the
+// body.
+func (w *Writer) writeHeadersOnce() error {
+	if err := w.usable(); err != nil {
+		return err
+	}
+	if w.state == StatusWriteStatusLine {
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			return err
+		}
+	}
+	if w.state == StatusWriteHeaders {
+		return w.WriteHeaders(nil, nil, nil)
+	}
+	return nil
+}
+
+// stream picks the mode of a buffered body that cannot wait for its end.
+func (w *Writer) stream() {
+	if w.Version == "1.0" {
+		w.mode = BodyCloseDelimited
+		w.KeepAlive = false
+		w.head.Set("Connection", "close")
+		return
+	}
+	w.mode = BodyChunked
+	w.head.Set("Transfer-Encoding", "chunked")
+}
+
+// commit sends the status line and headers if they did not go out yet.
+func (w *Writer) commit() error {
+	if w.committed {
+		return nil
+	}
+	w.committed = true
+	if w.mode == BodyChunked && len(w.trailerNames) > 0 {
+		w.head.Set("Trailer", strings.Join(w.trailerNames, ", "))
+	}
+
+	buff := bytes.NewBuffer([]byte{})
+	writeStatusLine(buff, w.Version, w.statusCode)
+	WriteHeaders(buff, w.head)
+	return w.write(buff.Bytes())
+}
+
+// send writes body bytes framed for the body mode.
+func (w *Writer) send(p []byte) error {
+	if w.Head || len(p) == 0 {
+		return nil
+	}
+	if w.mode != BodyChunked {
+		return w.write(p)
+	}
+
+	if err := w.write([]byte(strconv.FormatInt(int64(len(p)), 16) + CRLF)); err != nil {
+		return err
+	}
+	if err := w.write(p); err != nil {
+		return err
+	}
+	return w.write([]byte(CRLF))
+}
+
+// bodyAllowed reports whether a response with this status may carry a
+// body at all.
+func bodyAllowed(statusCode StatusCode) bool {
+	return statusCode >= 200 && statusCode != StatusNoContent && statusCode != StatusNotModified
+}
+
+// Redirect sends a 3xx response pointing the client to location.
+func (w *Writer) Redirect(code StatusCode, location string) error {
+	if code < 300 || code > 399 {
+		return fmt.Errorf("%w: %d is not a redirect", ErrInvalidStatusCode, code)
+	}
+	if err := headers.CheckField("Location", location); err != nil {
+		return err
+	}
+	h := headers.NewHeaders()
+	h.Set("Location", location)
+	if err := w.WriteStatusLine(code); err != nil {
+		return err
+	}
+	return w.WriteHeaders(h, []string{"Content-Type"}, nil)
+}
+
+// NoContent sends a 204 response, which has neither a body nor the headers
+// describing one.
+func (w *Writer) NoContent() error {
+	if err := w.WriteStatusLine(StatusNoContent); err != nil {
+		return err
+	}
+	return w.WriteHeaders(nil, []string{"Content-Length", "Content-Type"}, nil)
+}
+
+// Error sends a plain text response with message as its body, or the
+// reason phrase of code if message is empty.
+func (w *Writer) Error(code StatusCode, message string) error {
+	if message == "" {
+		message = StatusText(code)
+	}
+	h := headers.NewHeaders()
+	h.Set("Content-Length", strconv.Itoa(len(message)))
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	if err := w.WriteStatusLine(code); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h, nil, nil); err != nil {
+		return err
+	}
+	_, err := w.WriteBody([]byte(message))
+	return err
+}
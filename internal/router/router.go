@@ -0,0 +1,144 @@
+package router
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	server "tcpTohttp/internal"
+	"tcpTohttp/internal/request"
+	"tcpTohttp/internal/response"
+)
+
+// Router dispatches requests by method and path pattern. Patterns look like
+// "GET /users/{id}" or "/static/{path...}"; without a method the route
+// matches every method. A {name} parameter matches one path segment and a
+// trailing {name...} matches the rest of the path. Matched values are
+// available through request.Request.PathValue.
+type Router struct {
+	root node
+
+	// NotFound answers requests no route matches. It defaults to a plain
+	// 404 response.
+	NotFound server.Handler
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers h for pattern. It panics if the pattern is malformed or
+// already registered, like a duplicate route would be a programming error.
+func (r *Router) Handle(pattern string, h server.Handler) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		method, path = "", pattern
+	}
+	path = strings.TrimLeft(path, " ")
+	if !strings.HasPrefix(path, "/") {
+		panic(fmt.Sprintf("router: pattern %q must start with /", pattern))
+	}
+
+	n, err := r.root.insert(path)
+	if err != nil {
+		panic(fmt.Sprintf("router: pattern %q: %v", pattern, err))
+	}
+	if n.handlers == nil {
+		n.handlers = make(map[string]server.Handler)
+	}
+	if _, ok := n.handlers[method]; ok {
+		panic(fmt.Sprintf("router: pattern %q is already registered", pattern))
+	}
+	n.handlers[method] = h
+}
+
+func (r *Router) Get(path string, h server.Handler)    { r.Handle("GET "+path, h) }
+func (r *Router) Post(path string, h server.Handler)   { r.Handle("POST "+path, h) }
+func (r *Router) Put(path string, h server.Handler)    { r.Handle("PUT "+path, h) }
+func (r *Router) Patch(path string, h server.Handler)  { r.Handle("PATCH "+path, h) }
+func (r *Router) Delete(path string, h server.Handler) { r.Handle("DELETE "+path, h) }
+
+// ServeHTTP is a server.Handler. It answers 404 when no route matches the
+// path, 405 with an Allow header when routes match the path but not the
+// method, and OPTIONS with the allowed methods unless a route handles
+// OPTIONS itself. HEAD falls back to the GET route. Routes match the
+// decoded, dot-segment free URL path segment by segment: an escaped "/"
+// stays within its segment and path values are decoded on their own.
+func (r *Router) ServeHTTP(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	if req.URL.Form == request.FormAsterisk {
+		// OPTIONS * asks about the server as a whole, not a route
+		w.NoContent()
+		return
+	}
+	path := routingPath(req.URL.Segments)
+
+	n, params := r.root.lookup(path, nil, func(n *node) bool {
+		return n.handlerFor(method) != nil
+	})
+	if n != nil {
+		for _, p := range params {
+			req.SetPathValue(p.name, segmentUnescaper.Replace(p.value))
+		}
+		n.handlerFor(method)(w, req)
+		return
+	}
+
+	n, _ = r.root.lookup(path, nil, func(n *node) bool {
+		return len(n.handlers) > 0
+	})
+	if n == nil {
+		if r.NotFound != nil {
+			r.NotFound(w, req)
+			return
+		}
+		w.Error(response.StatusNotFound, "not found")
+		return
+	}
+
+	w.Header().Set("Allow", strings.Join(n.allowed(), ", "))
+	if method == "OPTIONS" {
+		w.NoContent()
+		return
+	}
+	w.Error(response.StatusMethodNotAllowed, "method not allowed")
+}
+
+var (
+	segmentEscaper   = strings.NewReplacer("%", "%25", "/", "%2F")
+	segmentUnescaper = strings.NewReplacer("%25", "%", "%2F", "/")
+)
+
+// routingPath joins decoded path segments back into a path to match routes
+// against, escaping the "/" and "%" within segments so a segment holding an
+// escaped "/" is not taken for two.
+func routingPath(segments []string) string {
+	var path strings.Builder
+	for _, segment := range segments {
+		path.WriteString("/" + segmentEscaper.Replace(segment))
+	}
+	return path.String()
+}
+
+func (n *node) handlerFor(method string) server.Handler {
+	if h, ok := n.handlers[method]; ok {
+		return h
+	}
+	if method == "HEAD" {
+		if h, ok := n.handlers["GET"]; ok {
+			return h
+		}
+	}
+	return n.handlers[""]
+}
+
+func (n *node) allowed() []string {
+	methods := []string{"OPTIONS"}
+	for method := range n.handlers {
+		methods = append(methods, method)
+		if method == "GET" {
+			methods = append(methods, "HEAD")
+		}
+	}
+	slices.Sort(methods)
+	return slices.Compact(methods)
+}
@@ -0,0 +1,97 @@
+package router
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcpTohttp/internal/request"
+	"tcpTohttp/internal/response"
+)
+
+func TestLookup(t *testing.T) {
+	r := New()
+	noop := func(w *response.Writer, req *request.Request) {}
+	r.Handle("GET /users", noop)
+	r.Handle("GET /users/new", noop)
+	r.Handle("GET /users/{id}", noop)
+	r.Handle("DELETE /users/{id}", noop)
+	r.Handle("GET /users/{id}/posts/{post}", noop)
+	r.Handle("/static/{path...}", noop)
+
+	anyMethod := func(n *node) bool { return len(n.handlers) > 0 }
+	tests := []struct {
+		path   string
+		found  bool
+		params []param
+	}{
+		{"/users", true, nil},
+		{"/users/new", true, nil},
+		{"/users/42", true, []param{{"id", "42"}}},
+		{"/users/newer", true, []param{{"id", "newer"}}},
+		{"/users/7/posts/9", true, []param{{"id", "7"}, {"post", "9"}}},
+		{"/users/7/posts", false, nil},
+		{"/static/css/site.css", true, []param{{"path", "css/site.css"}}},
+		{"/static/", true, []param{{"path", ""}}},
+		{"/user", false, nil},
+		{"/", false, nil},
+	}
+	for _, tt := range tests {
+		n, params := r.root.lookup(tt.path, nil, anyMethod)
+		if !tt.found {
+			assert.Nil(t, n, tt.path)
+			continue
+		}
+		require.NotNil(t, n, tt.path)
+		assert.Equal(t, tt.params, params, tt.path)
+	}
+
+	// method aware matching backtracks from the static route
+	n, params := r.root.lookup("/users/new", nil, func(n *node) bool {
+		return n.handlerFor("DELETE") != nil
+	})
+	require.NotNil(t, n)
+	assert.Equal(t, []param{{"id", "new"}}, params)
+
+	n, _ = r.root.lookup("/users/1", nil, anyMethod)
+	assert.Equal(t, []string{"DELETE", "GET", "HEAD", "OPTIONS"}, n.allowed())
+}
+
+func TestHandlePanics(t *testing.T) {
+	r := New()
+	noop := func(w *response.Writer, req *request.Request) {}
+	r.Handle("GET /a/{id}", noop)
+
+	assert.Panics(t, func() { r.Handle("GET /a/{id}", noop) })
+	assert.Panics(t, func() { r.Handle("GET /a/{name}", noop) })
+	assert.Panics(t, func() { r.Handle("GET /b/{path...}/c", noop) })
+	assert.Panics(t, func() { r.Handle("GET b", noop) })
+	assert.NotPanics(t, func() { r.Handle("POST /a/{id}", noop) })
+}
+
+func TestEscapedSlash(t *testing.T) {
+	r := New()
+	var got []string
+	r.Get("/files/{name}", func(w *response.Writer, req *request.Request) {
+		got = append(got, "name="+req.PathValue("name"))
+	})
+	r.Get("/files/{name}/meta", func(w *response.Writer, req *request.Request) {
+		got = append(got, "meta="+req.PathValue("name"))
+	})
+	r.Get("/raw/{path...}", func(w *response.Writer, req *request.Request) {
+		got = append(got, "path="+req.PathValue("path"))
+	})
+
+	for _, target := range []string{
+		"/files/a%2Fb", "/files/a%2Fb/meta", "/files/100%25", "/raw/x%2Fy/z%3F",
+	} {
+		req, err := request.RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		w := response.NewWriter(&bytes.Buffer{}, true)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, response.StatusCode(0), w.StatusCode(), target)
+	}
+	assert.Equal(t, []string{"name=a/b", "meta=a/b", "name=100%", "path=x/y/z?"}, got)
+}
@@ -0,0 +1,191 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+	server "tcpTohttp/internal"
+)
+
+// node is a radix tree node. Static children are keyed by their first byte
+// and share no common prefix; a node has at most one {param} child and one
+// {name...} wildcard child.
+type node struct {
+	prefix   string
+	children []*node
+
+	param     *node
+	paramName string
+
+	wildcard     *node
+	wildcardName string
+
+	// handlers by method, "" matches any method
+	handlers map[string]server.Handler
+}
+
+type param struct {
+	name  string
+	value string
+}
+
+type token struct {
+	static   string
+	param    string
+	wildcard bool
+}
+
+// tokenize splits "/users/{id}/files/{path...}" into static parts and
+// parameters.
+func tokenize(path string) ([]token, error) {
+	var tokens []token
+	for path != "" {
+		i := strings.IndexByte(path, '{')
+		if i == -1 {
+			tokens = append(tokens, token{static: path})
+			break
+		}
+		if i > 0 {
+			tokens = append(tokens, token{static: path[:i]})
+		}
+		if i > 0 && path[i-1] != '/' {
+			return nil, fmt.Errorf("parameter must start a segment")
+		}
+		end := strings.IndexByte(path[i:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("missing closing }")
+		}
+		name := path[i+1 : i+end]
+		path = path[i+end+1:]
+
+		tok := token{param: name}
+		if strings.HasSuffix(name, "...") {
+			tok = token{param: strings.TrimSuffix(name, "..."), wildcard: true}
+			if path != "" {
+				return nil, fmt.Errorf("wildcard {%s} must be last", name)
+			}
+		} else if path != "" && path[0] != '/' {
+			return nil, fmt.Errorf("parameter must end a segment")
+		}
+		if tok.param == "" || strings.ContainsAny(tok.param, "{}/") {
+			return nil, fmt.Errorf("invalid parameter name %q", name)
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, nil
+}
+
+func (n *node) insert(path string) (*node, error) {
+	tokens, err := tokenize(path)
+	if err != nil {
+		return nil, err
+	}
+
+	curr := n
+	for _, tok := range tokens {
+		switch {
+		case tok.wildcard:
+			if curr.wildcard == nil {
+				curr.wildcard = &node{}
+				curr.wildcardName = tok.param
+			} else if curr.wildcardName != tok.param {
+				return nil, fmt.Errorf("wildcard {%s...} conflicts with {%s...}",
+					tok.param, curr.wildcardName)
+			}
+			curr = curr.wildcard
+
+		case tok.param != "":
+			if curr.param == nil {
+				curr.param = &node{}
+				curr.paramName = tok.param
+			} else if curr.paramName != tok.param {
+				return nil, fmt.Errorf("parameter {%s} conflicts with {%s}",
+					tok.param, curr.paramName)
+			}
+			curr = curr.param
+
+		default:
+			curr = curr.insertStatic(tok.static)
+		}
+	}
+	return curr, nil
+}
+
+// insertStatic walks down s from n, splitting nodes where s diverges from
+// an existing prefix, and returns the node s ends at.
+func (n *node) insertStatic(s string) *node {
+	for s != "" {
+		var child *node
+		idx := 0
+		for i, c := range n.children {
+			if c.prefix[0] == s[0] {
+				child, idx = c, i
+				break
+			}
+		}
+		if child == nil {
+			child = &node{prefix: s}
+			n.children = append(n.children, child)
+			return child
+		}
+
+		l := commonPrefix(child.prefix, s)
+		if l < len(child.prefix) {
+			split := &node{prefix: child.prefix[:l], children: []*node{child}}
+			child.prefix = child.prefix[l:]
+			n.children[idx] = split
+			child = split
+		}
+		n, s = child, s[l:]
+	}
+	return n
+}
+
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// lookup finds the node matching path, preferring static segments over
+// parameters and parameters over wildcards. match filters candidate nodes,
+// backtracking when it rejects one.
+func (n *node) lookup(path string, params []param, match func(*node) bool) (*node, []param) {
+	if path == "" {
+		if len(n.handlers) > 0 && match(n) {
+			return n, params
+		}
+		if n.wildcard != nil && match(n.wildcard) {
+			return n.wildcard, append(params, param{n.wildcardName, ""})
+		}
+		return nil, nil
+	}
+
+	for _, c := range n.children {
+		if strings.HasPrefix(path, c.prefix) {
+			if found, p := c.lookup(path[len(c.prefix):], params, match); found != nil {
+				return found, p
+			}
+			break
+		}
+	}
+
+	if n.param != nil {
+		end := strings.IndexByte(path, '/')
+		if end == -1 {
+			end = len(path)
+		}
+		if end > 0 {
+			p := append(params, param{n.paramName, path[:end]})
+			if found, p := n.param.lookup(path[end:], p, match); found != nil {
+				return found, p
+			}
+		}
+	}
+
+	if n.wildcard != nil && match(n.wildcard) {
+		return n.wildcard, append(params, param{n.wildcardName, path})
+	}
+	return nil, nil
+}
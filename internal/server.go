@@ -1,13 +1,23 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
+	"runtime/debug"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"tcpTohttp/internal/headers"
 	"tcpTohttp/internal/request"
 	"tcpTohttp/internal/response"
+	"time"
 )
 
 type HandlerError struct {
@@ -18,9 +28,102 @@ type Server struct {
 	listener net.Listener
 	runing   atomic.Bool
 	Handler  Handler
+
+	config Config
+
+	mu         sync.Mutex
+	conns      map[net.Conn]connState
+	onShutdown []func()
 }
+
+type connState int
+
+const (
+	// waiting for the next request
+	connIdle connState = iota
+	// a request is being handled
+	connActive
+)
+
 type Handler func(w *response.Writer, req *request.Request)
 
+// Middleware wraps a Handler with behavior that runs around it.
+type Middleware func(Handler) Handler
+
+// Chain composes middlewares so the first one given is the outermost.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(h Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			h = middlewares[i](h)
+		}
+		return h
+	}
+}
+
+// Config tunes a Server. A zero duration or count means no limit.
+type Config struct {
+	// ReadHeaderTimeout bounds reading the request line and headers,
+	// counted from the first byte of the request. The first request on a
+	// connection is counted from accept instead.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds reading the whole request, body included, counted
+	// like ReadHeaderTimeout.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds writing the response, counted from the end of
+	// the request header.
+	WriteTimeout time.Duration
+	// IdleTimeout bounds the wait for the next request on a kept-alive
+	// connection. When zero, ReadTimeout is used.
+	IdleTimeout time.Duration
+
+	MaxRequestsPerConn int
+	// Limits bounds request line, header and body sizes.
+	Limits request.Limits
+
+	// TLSConfig turns on HTTPS when set. See CertStore for SNI and
+	// certificate reloading.
+	TLSConfig *tls.Config
+
+	// Methods lists the methods the server implements, others are answered
+	// with 501. Nil means DefaultMethods. Adding TRACE turns on the
+	// server's own TRACE responses; handlers never see TRACE requests.
+	Methods []string
+}
+
+// DefaultMethods are the methods of RFC 9110 but TRACE, which can leak
+// credentials to scripts and is off unless asked for.
+var DefaultMethods = []string{
+	"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "PATCH",
+}
+
+// traceHiddenHeaders are left out of TRACE responses since they carry
+// credentials.
+var traceHiddenHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Cookie",
+}
+
+const (
+	DefaultMaxRequestsPerConn = 100
+	DefaultIdleTimeout        = 60 * time.Second
+	DefaultReadHeaderTimeout  = 10 * time.Second
+	DefaultReadTimeout        = 30 * time.Second
+	DefaultWriteTimeout       = 30 * time.Second
+
+	shutdownPollInterval = 50 * time.Millisecond
+)
+
+// DefaultConfig returns the config used by Serve.
+func DefaultConfig() Config {
+	return Config{
+		ReadHeaderTimeout:  DefaultReadHeaderTimeout,
+		ReadTimeout:        DefaultReadTimeout,
+		WriteTimeout:       DefaultWriteTimeout,
+		IdleTimeout:        DefaultIdleTimeout,
+		MaxRequestsPerConn: DefaultMaxRequestsPerConn,
+		Limits:             request.DefaultLimits(),
+	}
+}
+
 func WriteError(h HandlerError, w io.Writer) {
 	response.WriteStatusLine(w, h.StatusCode)
 	headers := response.GetDefaultHeaders(len(h.Message))
@@ -29,11 +132,23 @@ func WriteError(h HandlerError, w io.Writer) {
 }
 
 func Serve(port int, handler Handler) (*Server, error) {
+	return ServeWithConfig(port, handler, DefaultConfig())
+}
+
+func ServeWithConfig(port int, handler Handler, config Config) (*Server, error) {
 	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
 	if err != nil {
 		return nil, err
 	}
-	server := &Server{listener: listener, Handler: handler}
+	if config.TLSConfig != nil {
+		listener = tls.NewListener(listener, config.TLSConfig)
+	}
+	server := &Server{
+		listener: listener,
+		Handler:  handler,
+		config:   config,
+		conns:    make(map[net.Conn]connState),
+	}
 
 	server.runing.Store(true)
 	go server.listen()
@@ -50,32 +165,370 @@ func (s *Server) Close() error {
 	return s.listener.Close()
 }
 
+// RegisterOnShutdown registers a function to call when Shutdown starts.
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.mu.Lock()
+	s.onShutdown = append(s.onShutdown, f)
+	s.mu.Unlock()
+}
+
+// Shutdown stops accepting connections, closes idle ones and waits for the
+// active ones to finish their current request. When ctx expires first the
+// remaining connections are closed and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.runing.Store(false)
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for _, f := range s.onShutdown {
+		go f()
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.closeAllConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns closes every idle connection and reports whether no
+// connection is left.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, state := range s.conns {
+		if state == connIdle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) closeAllConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+func (s *Server) setConnState(conn net.Conn, state connState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.conns[conn]; ok {
+		s.conns[conn] = state
+	}
+}
+
+// trackConn adds conn to the tracked set. It returns false once the server
+// is shutting down.
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isClosed() {
+		return false
+	}
+	s.conns[conn] = connIdle
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
 		if s.isClosed() {
+			if conn != nil {
+				conn.Close()
+			}
 			return
 		}
 		if err != nil {
 			log.Println(err)
 			return
 		}
+		if !s.trackConn(conn) {
+			conn.Close()
+			return
+		}
 		go s.handle(conn)
 
 	}
 }
 
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
+	// a hijacked connection belongs to the handler that took it
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			conn.Close()
+		}
+	}()
+	defer s.untrackConn(conn)
 
-	request, err := request.RequestFromReader(conn)
-	if err != nil {
-		handlerError := &HandlerError{StatusCode: 500, Message: err.Error()}
+	accepted := time.Now()
+	reader := request.NewReaderWithLimits(conn, s.config.Limits)
+	for served := 0; !s.servedEnough(served); served++ {
+		s.setConnState(conn, connIdle)
+		if served == 0 {
+			conn.SetReadDeadline(deadline(accepted,
+				s.config.ReadHeaderTimeout, s.config.ReadTimeout))
+		} else {
+			conn.SetReadDeadline(deadline(time.Now(), s.idleTimeout()))
+		}
+		if err := reader.Wait(); err != nil {
+			return
+		}
+		s.setConnState(conn, connActive)
+
+		// a client trickling in the first request gets no fresh budget
+		// for its first byte
+		start := time.Now()
+		if served == 0 {
+			start = accepted
+		}
+		req, err := s.readRequest(conn, reader, start)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Println(err)
+			conn.SetWriteDeadline(deadline(time.Now(), s.config.WriteTimeout))
+			WriteError(parseError(err), conn)
+			return
+		}
+		conn.SetWriteDeadline(deadline(time.Now(), s.config.WriteTimeout))
+
+		if !s.implements(req.RequestLine.Method) {
+			err := fmt.Errorf("%w: %q", request.ErrMethodNotImplemented, req.RequestLine.Method)
+			log.Println(err)
+			WriteError(parseError(err), conn)
+			return
+		}
+
+		keepAlive := req.KeepAlive() &&
+			!s.servedEnough(served+1) && !s.isClosed()
+		writer := response.NewWriter(conn, keepAlive)
+		writer.SetHijacker(func() (net.Conn, []byte, error) {
+			hijacked = true
+			s.untrackConn(conn)
+			conn.SetDeadline(time.Time{})
+			return conn, reader.Detach(), nil
+		})
+		reuse := s.respond(writer, req, reader)
+		writer.Release()
+		if !reuse {
+			return
+		}
+	}
+}
+
+// respond writes the response to req, returning whether the connection can
+// carry another request.
+func (s *Server) respond(writer *response.Writer, req *request.Request, reader *request.Reader) bool {
+	keepAlive := writer.KeepAlive
+	writer.Head = req.RequestLine.Method == "HEAD"
+	writer.Version = req.RequestLine.HttpVersion
+	if req.ExpectsContinue() {
+		// a response sent before asking for the body leaves the
+		// connection in an unknown state, the body may or may not follow
+		writer.KeepAlive = false
+		req.SetContinue(func() error {
+			if writer.Status() != response.StatusWriteStatusLine {
+				return nil
+			}
+			writer.KeepAlive = keepAlive
+			return writer.WriteInformational(response.StatusContinue, nil)
+		})
+	}
+	if req.RequestLine.Method == "TRACE" {
+		trace(writer, req)
+	} else if !s.serve(writer, req) {
+		return false
+	}
+
+	if writer.Status() == response.StatusWriteHijacked {
+		return false
+	}
+	if writer.Status() == response.StatusWriteStatusLine {
+		// a body that broke a limit or failed to parse is answered with
+		// the error, the handler may have given up on it without answering
+		if err := reader.DiscardBody(); err != nil {
+			writer.KeepAlive = false
+			if !errors.Is(err, request.ErrBodyNotConsumed) {
+				handlerError := parseError(err)
+				writer.Error(handlerError.StatusCode, handlerError.Message)
+			}
+		}
+	}
+	if err := writer.Finish(); err != nil {
 		log.Println(err)
-		WriteError(*handlerError, conn)
+		return false
+	}
+	if !writer.Reusable() || s.isClosed() {
+		return false
+	}
+	return reader.DiscardBody() == nil
+}
+
+// serve runs the handler, recovering from a panic in it with RecoverPanic.
+// It returns false if the handler panicked, in which case the connection
+// must be closed.
+func (s *Server) serve(w *response.Writer, req *request.Request) (ok bool) {
+	defer func() {
+		rec := recover()
+		if rec == nil {
+			return
+		}
+		ok = false
+		RecoverPanic(w, req, rec)
+		w.Finish()
+	}()
+
+	s.Handler(w, req)
+	return true
+}
+
+// RecoverPanic logs a panic recovered from the handler of req and answers
+// with a 500 if nothing of the response went out yet. Otherwise the
+// response is aborted, leaving the client a truncated one rather than a
+// wrong one. Either way the connection is closed after it.
+func RecoverPanic(w *response.Writer, req *request.Request, rec any) {
+	log.Printf("panic serving %s %s: %v\n%s",
+		req.RequestLine.Method, req.RequestLine.RequestTarget, rec, debug.Stack())
+
+	if w.Reset() != nil {
+		w.Abort()
 		return
 	}
-	writer := &response.Writer{Conn: conn, Status: response.StatusWriteStatusLine}
-	s.Handler(writer, request)
+	w.KeepAlive = false
+	w.Error(response.StatusInternalServerError, "internal server error")
+}
+
+func (s *Server) implements(method string) bool {
+	methods := s.config.Methods
+	if methods == nil {
+		methods = DefaultMethods
+	}
+	return slices.Contains(methods, method)
+}
+
+// trace answers a TRACE request with the request head it received, as a
+// message/http body.
+func trace(w *response.Writer, req *request.Request) {
+	var body strings.Builder
+	body.WriteString(req.RequestLine.Method + " " + req.RequestLine.RequestTarget +
+		" HTTP/" + req.RequestLine.HttpVersion + request.CRLF)
+	req.Headers.Range(func(key, val string) bool {
+		for _, hidden := range traceHiddenHeaders {
+			if strings.EqualFold(key, hidden) {
+				return true
+			}
+		}
+		body.WriteString(key + ": " + val + request.CRLF)
+		return true
+	})
+	body.WriteString(request.CRLF)
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "message/http")
+	h.Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h, nil, nil)
+	w.WriteBody([]byte(body.String()))
+}
+
+// parseErrors maps request parse failures to the status sent back. The
+// message is the error's own text, which never includes request data.
+var parseErrors = []struct {
+	err        error
+	statusCode response.StatusCode
+}{
+	{request.ErrMalformedRequestLine, response.StatusBadRequest},
+	{headers.ErrMalformedHeader, response.StatusBadRequest},
+	{headers.ErrInvalidHeaderName, response.StatusBadRequest},
+	{request.ErrMalformedChunk, response.StatusBadRequest},
+	{request.ErrInvalidContentLength, response.StatusBadRequest},
+	{request.ErrAmbiguousFraming, response.StatusBadRequest},
+	{request.ErrMethodNotImplemented, response.StatusNotImplemented},
+	{request.ErrUnsupportedTransferEncoding, response.StatusNotImplemented},
+	{request.ErrUnsupportedVersion, response.StatusVersionNotSupported},
+	{request.ErrURITooLong, response.StatusURITooLong},
+	{request.ErrHeaderTooLarge, response.StatusHeaderTooLarge},
+	{request.ErrBodyTooLarge, response.StatusContentTooLarge},
+	{request.ErrExpectationFailed, response.StatusExpectationFailed},
+}
+
+func parseError(err error) HandlerError {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return HandlerError{StatusCode: response.StatusRequestTimeout, Message: "request timeout"}
+	}
+	for _, e := range parseErrors {
+		if errors.Is(err, e.err) {
+			return HandlerError{StatusCode: e.statusCode, Message: e.err.Error()}
+		}
+	}
+	return HandlerError{StatusCode: response.StatusBadRequest, Message: "bad request"}
 }
 
+// readRequest reads the head of the request whose first byte is already
+// buffered, bounded by ReadHeaderTimeout counted from start. The handler
+// then reads the body under what is left of ReadTimeout.
+func (s *Server) readRequest(conn net.Conn, reader *request.Reader, start time.Time) (*request.Request, error) {
+	conn.SetReadDeadline(deadline(start,
+		s.config.ReadHeaderTimeout, s.config.ReadTimeout))
+
+	req, err := reader.ReadRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(deadline(start, s.config.ReadTimeout))
+	return req, nil
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.config.IdleTimeout > 0 {
+		return s.config.IdleTimeout
+	}
+	return s.config.ReadTimeout
+}
+
+func (s *Server) servedEnough(served int) bool {
+	return s.config.MaxRequestsPerConn > 0 && served >= s.config.MaxRequestsPerConn
+}
+
+// deadline returns the earliest of start plus each non-zero timeout, or the
+// zero time when every timeout is zero.
+func deadline(start time.Time, timeouts ...time.Duration) time.Time {
+	var d time.Time
+	for _, timeout := range timeouts {
+		if timeout <= 0 {
+			continue
+		}
+		if t := start.Add(timeout); d.IsZero() || t.Before(d) {
+			d = t
+		}
+	}
+	return d
+}
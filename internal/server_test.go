@@ -0,0 +1,400 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcpTohttp/internal/request"
+	"tcpTohttp/internal/response"
+)
+
+// startServer serves h on a free port until the test ends.
+func startServer(t *testing.T, h Handler, config Config) (*Server, string) {
+	t.Helper()
+	s, err := ServeWithConfig(0, h, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, s.listener.Addr().String()
+}
+
+// testClient is one connection to a test server.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &testClient{t: t, conn: conn, br: bufio.NewReader(conn)}
+}
+
+func (c *testClient) send(raw string) {
+	c.t.Helper()
+	_, err := io.WriteString(c.conn, raw)
+	require.NoError(c.t, err)
+}
+
+// response reads the next response, body included.
+func (c *testClient) response(method string) (*http.Response, string) {
+	c.t.Helper()
+	res, err := http.ReadResponse(c.br, &http.Request{Method: method})
+	require.NoError(c.t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(c.t, err)
+	res.Body.Close()
+	return res, string(body)
+}
+
+// closed reports whether the server closed the connection with nothing
+// more sent.
+func (c *testClient) closed() bool {
+	_, err := c.br.ReadByte()
+	return err == io.EOF
+}
+
+func TestEmptyResponse(t *testing.T) {
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {}, DefaultConfig())
+	c := dial(t, addr)
+
+	for range 2 {
+		c.send("GET /empty HTTP/1.1\r\nHost: x\r\n\r\n")
+		res, body := c.response("GET")
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, int64(0), res.ContentLength)
+		assert.Empty(t, body)
+		assert.False(t, res.Close)
+	}
+
+	// a body too large to discard still gets the answer, then the close
+	c.send("POST /empty HTTP/1.1\r\nHost: x\r\nContent-Length: 1000000\r\n\r\n")
+	res, _ := c.response("POST")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, res.Close)
+	assert.True(t, c.closed())
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.ReadHeaderTimeout = 300 * time.Millisecond
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {}, config)
+
+	// the first byte comes late and the rest trickles in: the deadline is
+	// still counted from accept
+	c := dial(t, addr)
+	start := time.Now()
+	go func(conn net.Conn) {
+		time.Sleep(250 * time.Millisecond)
+		for _, b := range []byte("GET / HTTP/1.1\r\nHost: x\r\n") {
+			if _, err := conn.Write([]byte{b}); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}(c.conn)
+	res, _ := c.response("GET")
+	elapsed := time.Since(start)
+	assert.Equal(t, http.StatusRequestTimeout, res.StatusCode)
+	assert.Less(t, elapsed, 500*time.Millisecond)
+	assert.GreaterOrEqual(t, elapsed, 300*time.Millisecond)
+
+	// a kept-alive connection gives the next request its own budget from
+	// its first byte
+	c = dial(t, addr)
+	c.send("GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	res, _ = c.response("GET")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	time.Sleep(400 * time.Millisecond)
+	c.send("GET / HTTP/1.1\r\n")
+	time.Sleep(200 * time.Millisecond)
+	c.send("Host: x\r\n\r\n")
+	res, _ = c.response("GET")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+// echoPath answers with the request path.
+func echoPath(w *response.Writer, req *request.Request) {
+	w.Write([]byte(req.URL.Path))
+}
+
+func TestKeepAlive(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxRequestsPerConn = 3
+	_, addr := startServer(t, echoPath, config)
+
+	// pipelined requests are answered in order on one connection
+	c := dial(t, addr)
+	c.send("GET /a HTTP/1.1\r\nHost: x\r\n\r\nGET /b HTTP/1.1\r\nHost: x\r\n\r\n")
+	for _, path := range []string{"/a", "/b"} {
+		res, body := c.response("GET")
+		assert.Equal(t, path, body)
+		assert.False(t, res.Close)
+	}
+	// the last request allowed on the connection is told it is the last
+	c.send("GET /c HTTP/1.1\r\nHost: x\r\n\r\n")
+	res, body := c.response("GET")
+	assert.Equal(t, "/c", body)
+	assert.True(t, res.Close)
+	assert.True(t, c.closed())
+
+	c = dial(t, addr)
+	c.send("GET /a HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+	res, _ = c.response("GET")
+	assert.True(t, res.Close)
+	assert.True(t, c.closed())
+
+	// HTTP/1.0 closes unless asked to keep the connection
+	c = dial(t, addr)
+	c.send("GET /a HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+	res, _ = c.response("GET")
+	assert.False(t, res.Close)
+	c.send("GET /b HTTP/1.0\r\n\r\n")
+	res, body = c.response("GET")
+	assert.Equal(t, "/b", body)
+	assert.True(t, c.closed())
+
+	// an unread body is skipped to get to the next request
+	c = dial(t, addr)
+	c.send("POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /b HTTP/1.1\r\nHost: x\r\n\r\n")
+	c.response("POST")
+	_, body = c.response("GET")
+	assert.Equal(t, "/b", body)
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	s, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		w.Write([]byte("done"))
+	}, DefaultConfig())
+
+	idle := dial(t, addr)
+	idle.send("GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	idle.response("GET")
+	active := dial(t, addr)
+	active.send("GET /slow HTTP/1.1\r\nHost: x\r\n\r\n")
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	// the idle connection goes at once, the active one finishes its request
+	assert.True(t, idle.closed())
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v with a request in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	_, body := active.response("GET")
+	assert.Equal(t, "done", body)
+	assert.True(t, active.closed())
+	assert.NoError(t, <-shutdown)
+
+	_, err := net.Dial("tcp", addr)
+	assert.Error(t, err)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	s, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	}, DefaultConfig())
+
+	c := dial(t, addr)
+	c.send("GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	<-started
+
+	// a request still running when ctx expires loses its connection
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+	_, err := c.br.ReadByte()
+	assert.Error(t, err)
+}
+
+func TestReadTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.ReadTimeout = 300 * time.Millisecond
+	config.IdleTimeout = 200 * time.Millisecond
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		if _, err := io.ReadAll(req.Body); err != nil {
+			return
+		}
+		w.Write([]byte("ok"))
+	}, config)
+
+	// a body that stops short of its length runs out of time
+	c := dial(t, addr)
+	c.send("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 10\r\n\r\nhello")
+	res, _ := c.response("POST")
+	assert.Equal(t, http.StatusRequestTimeout, res.StatusCode)
+	assert.True(t, res.Close)
+
+	// a kept-alive connection left idle is closed without a response
+	c = dial(t, addr)
+	c.send("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello")
+	_, body := c.response("POST")
+	assert.Equal(t, "ok", body)
+	start := time.Now()
+	assert.True(t, c.closed())
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestReadTimeoutOnly(t *testing.T) {
+	config := DefaultConfig()
+	config.ReadHeaderTimeout = 0
+	config.ReadTimeout = 200 * time.Millisecond
+	config.IdleTimeout = 0
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {}, config)
+
+	// a client that never sends a byte is dropped all the same
+	c := dial(t, addr)
+	start := time.Now()
+	assert.True(t, c.closed())
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+
+	// one that stops mid-header gets a 408
+	c = dial(t, addr)
+	start = time.Now()
+	c.send("GET / HTTP/1.1\r\n")
+	res, _ := c.response("GET")
+	assert.Equal(t, http.StatusRequestTimeout, res.StatusCode)
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+
+	// and the wait for a next request falls back to ReadTimeout
+	c = dial(t, addr)
+	c.send("GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	c.response("GET")
+	start = time.Now()
+	assert.True(t, c.closed())
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestPanic(t *testing.T) {
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.Write([]byte("partial"))
+		if req.URL.Path == "/committed" {
+			w.Flush()
+		}
+		panic("boom")
+	}, DefaultConfig())
+
+	// nothing went out yet: the partial response is replaced by a 500
+	c := dial(t, addr)
+	c.send("GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	res, body := c.response("GET")
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	assert.Equal(t, "internal server error", body)
+	assert.True(t, res.Close)
+	assert.True(t, c.closed())
+
+	// the head is out: the body is cut short, no 500 follows
+	c = dial(t, addr)
+	c.send("GET /committed HTTP/1.1\r\nHost: x\r\n\r\n")
+	raw, err := io.ReadAll(c.br)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, string(raw), "partial")
+	assert.NotContains(t, string(raw), "500")
+	assert.False(t, strings.HasSuffix(string(raw), "0\r\n\r\n"))
+}
+
+func TestParseErrors(t *testing.T) {
+	config := DefaultConfig()
+	config.Limits = request.Limits{
+		MaxRequestLineBytes: 64,
+		MaxHeaderBytes:      128,
+		MaxBodyBytes:        8,
+	}
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		if _, err := io.ReadAll(req.Body); err != nil {
+			return
+		}
+		w.Write([]byte("ok"))
+	}, config)
+
+	tests := []struct {
+		name       string
+		raw        string
+		statusCode int
+	}{
+		{"malformed request line", "GET /\r\n\r\n", http.StatusBadRequest},
+		{"malformed header", "GET / HTTP/1.1\r\nHost x\r\n\r\n", http.StatusBadRequest},
+		{"invalid header name", "GET / HTTP/1.1\r\nHo(st: x\r\n\r\n", http.StatusBadRequest},
+		{"invalid content-length", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: -1\r\n\r\n", http.StatusBadRequest},
+		{"ambiguous framing", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n", http.StatusBadRequest},
+		{"malformed chunk", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", http.StatusBadRequest},
+		{"unknown method", "BREW / HTTP/1.1\r\nHost: x\r\n\r\n", http.StatusNotImplemented},
+		{"unsupported transfer encoding", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: gzip\r\n\r\n", http.StatusNotImplemented},
+		{"unsupported version", "GET / HTTP/2.0\r\nHost: x\r\n\r\n", http.StatusHTTPVersionNotSupported},
+		{"uri too long", "GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\nHost: x\r\n\r\n", http.StatusRequestURITooLong},
+		{"header too large", "GET / HTTP/1.1\r\nHost: x\r\nX-Big: " + strings.Repeat("a", 200) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge},
+		{"body too large", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 9\r\n\r\n123456789", http.StatusRequestEntityTooLarge},
+		{"unsupported expectation", "POST / HTTP/1.1\r\nHost: x\r\nExpect: teapot\r\nContent-Length: 1\r\n\r\n1", http.StatusExpectationFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dial(t, addr)
+			c.send(tt.raw)
+			res, _ := c.response("GET")
+			assert.Equal(t, tt.statusCode, res.StatusCode)
+			assert.True(t, c.closed())
+		})
+	}
+}
+
+func TestMethods(t *testing.T) {
+	_, addr := startServer(t, echoPath, DefaultConfig())
+
+	// TRACE is off by default
+	c := dial(t, addr)
+	c.send("TRACE / HTTP/1.1\r\nHost: x\r\n\r\n")
+	res, _ := c.response("TRACE")
+	assert.Equal(t, http.StatusNotImplemented, res.StatusCode)
+
+	config := DefaultConfig()
+	config.Methods = []string{"GET", "TRACE"}
+	_, addr = startServer(t, func(w *response.Writer, req *request.Request) {
+		assert.NotEqual(t, "TRACE", req.RequestLine.Method)
+		echoPath(w, req)
+	}, config)
+
+	c = dial(t, addr)
+	c.send("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n")
+	res, _ = c.response("POST")
+	assert.Equal(t, http.StatusNotImplemented, res.StatusCode)
+	assert.True(t, c.closed())
+
+	// the echo leaves out credentials
+	c = dial(t, addr)
+	c.send("TRACE /path HTTP/1.1\r\nHost: x\r\nAuthorization: Basic c2VjcmV0\r\n" +
+		"Cookie: session=secret\r\nX-Trace: kept\r\n\r\n")
+	res, body := c.response("TRACE")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "message/http", res.Header.Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(body, "TRACE /path HTTP/1.1\r\n"))
+	assert.Contains(t, body, "X-Trace: kept\r\n")
+	assert.NotContains(t, body, "secret")
+	assert.NotContains(t, body, "c2VjcmV0")
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// CertFile is a PEM encoded certificate chain and its private key on disk.
+type CertFile struct {
+	CertFile string
+	KeyFile  string
+}
+
+// CertStore holds the certificates served over TLS and picks one per
+// handshake from the SNI server name. Certificates can be reloaded at any
+// time; connections already established keep the certificate they started
+// with.
+type CertStore struct {
+	files []CertFile
+
+	mu       sync.RWMutex
+	byName   map[string]*tls.Certificate
+	fallback *tls.Certificate
+	modTimes map[string]time.Time
+}
+
+func NewCertStore(files ...CertFile) (*CertStore, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no certificate given")
+	}
+	store := &CertStore{files: files}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload reads every certificate from disk again. The old certificates are
+// kept if any file fails to load.
+func (c *CertStore) Reload() error {
+	byName := make(map[string]*tls.Certificate)
+	modTimes := make(map[string]time.Time)
+	var fallback *tls.Certificate
+
+	for _, file := range c.files {
+		cert, err := tls.LoadX509KeyPair(file.CertFile, file.KeyFile)
+		if err != nil {
+			return err
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		cert.Leaf = leaf
+
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			byName[strings.ToLower(name)] = &cert
+		}
+		if fallback == nil {
+			fallback = &cert
+		}
+
+		for _, path := range []string{file.CertFile, file.KeyFile} {
+			if info, err := os.Stat(path); err == nil {
+				modTimes[path] = info.ModTime()
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.byName, c.fallback, c.modTimes = byName, fallback, modTimes
+	c.mu.Unlock()
+	return nil
+}
+
+// GetCertificate matches the SNI name exactly, then against a wildcard
+// certificate one label up, and falls back to the first certificate.
+func (c *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := c.byName[name]; ok {
+		return cert, nil
+	}
+	if i := strings.Index(name, "."); i != -1 {
+		if cert, ok := c.byName["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return c.fallback, nil
+}
+
+// TLSConfig returns a config serving the store's certificates.
+func (c *CertStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+}
+
+// changed reports whether any certificate or key file was modified since
+// the last reload.
+func (c *CertStore) changed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for path, modTime := range c.modTimes {
+		info, err := os.Stat(path)
+		if err == nil && !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads the certificates on SIGHUP and whenever a file changes on
+// disk, checking every interval. Calling the returned function stops it.
+func (c *CertStore) Watch(interval time.Duration) (stop func()) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		defer signal.Stop(sigChan)
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-sigChan:
+			case <-ticker.C:
+				if !c.changed() {
+					continue
+				}
+			}
+			if err := c.Reload(); err != nil {
+				log.Println("certificate reload failed:", err)
+				continue
+			}
+			log.Println("certificates reloaded")
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// GenerateSelfSignedCert writes a self-signed certificate and key valid for
+// the given host names and IP addresses, for local development.
+func GenerateSelfSignedCert(certFile, keyFile string, hosts ...string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"tcpTohttp dev"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if len(hosts) > 0 {
+		template.Subject.CommonName = hosts[0]
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPem, 0644); err != nil {
+		return err
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	return os.WriteFile(keyFile, keyPem, 0600)
+}
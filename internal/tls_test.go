@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert generates a self-signed certificate for hosts in dir.
+func writeCert(t *testing.T, dir, name string, hosts ...string) CertFile {
+	t.Helper()
+	file := CertFile{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, GenerateSelfSignedCert(file.CertFile, file.KeyFile, hosts...))
+	return file
+}
+
+// servedName returns the first name of the certificate served for the SNI
+// name serverName.
+func servedName(t *testing.T, store *CertStore, serverName string) string {
+	t.Helper()
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	require.NoError(t, err)
+	return cert.Leaf.DNSNames[0]
+}
+
+func TestCertStoreGetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewCertStore(
+		writeCert(t, dir, "default", "default.test"),
+		writeCert(t, dir, "exact", "example.com"),
+		writeCert(t, dir, "wildcard", "*.example.org"),
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"example.com", "example.com"},
+		{"EXAMPLE.com.", "example.com"},
+		{"www.example.org", "*.example.org"},
+		// a wildcard covers one label only
+		{"a.www.example.org", "default.test"},
+		{"example.org", "default.test"},
+		{"unknown.test", "default.test"},
+		{"", "default.test"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, servedName(t, store, tt.serverName), tt.serverName)
+	}
+}
+
+func TestCertStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	file := writeCert(t, dir, "site", "old.test")
+	store, err := NewCertStore(file)
+	require.NoError(t, err)
+	assert.False(t, store.changed())
+
+	// a broken file fails the reload and the old certificate stays
+	require.NoError(t, os.WriteFile(file.CertFile, []byte("not a certificate"), 0644))
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(file.CertFile, later, later))
+	assert.True(t, store.changed())
+	assert.Error(t, store.Reload())
+	assert.Equal(t, "old.test", servedName(t, store, "old.test"))
+
+	writeCert(t, dir, "site", "new.test")
+	require.NoError(t, store.Reload())
+	assert.False(t, store.changed())
+	assert.Equal(t, "new.test", servedName(t, store, "old.test"))
+}
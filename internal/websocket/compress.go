@@ -0,0 +1,154 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// permessage-deflate, RFC 7692. Both sides compress every message on its
+// own, without context takeover, which keeps no compression state between
+// messages.
+
+const deflateExtension = "permessage-deflate"
+
+// deflateResponse is the extension the server agrees to.
+const deflateResponse = deflateExtension + "; server_no_context_takeover; client_no_context_takeover"
+
+// deflateTail ends a compressed message: the empty block every message is
+// stripped of, then a final empty block so the reader sees the end.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+var flateWriterPool = sync.Pool{
+	New: func() any {
+		fw, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return fw
+	},
+}
+
+// acceptDeflate reports whether one of the offers in a
+// Sec-WebSocket-Extensions value is a permessage-deflate the server can
+// honour. The window of compress/flate is fixed, so an offer asking the
+// server for a smaller one is declined.
+func acceptDeflate(extensions string) bool {
+	for offer := range strings.SplitSeq(extensions, ",") {
+		params := strings.Split(offer, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), deflateExtension) {
+			continue
+		}
+		if deflateParamsOK(params[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func deflateParamsOK(params []string) bool {
+	for _, param := range params {
+		name, value, _ := strings.Cut(param, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		switch name {
+		case "server_no_context_takeover", "client_no_context_takeover":
+		case "client_max_window_bits":
+			// the client may use any window, ours has the largest
+		case "server_max_window_bits":
+			if value != "15" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// deflateWriter compresses a message into w. Close ends the message,
+// leaving out the empty block the deflate stream is flushed with.
+type deflateWriter struct {
+	fw *flate.Writer
+	tw trimWriter
+}
+
+func newDeflateWriter(w io.Writer) *deflateWriter {
+	d := &deflateWriter{fw: flateWriterPool.Get().(*flate.Writer)}
+	d.tw.w = w
+	d.fw.Reset(&d.tw)
+	return d
+}
+
+func (d *deflateWriter) Write(p []byte) (int, error) {
+	return d.fw.Write(p)
+}
+
+func (d *deflateWriter) Close() error {
+	err := d.fw.Flush()
+	flateWriterPool.Put(d.fw)
+	d.fw = nil
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(d.tw.tail[:d.tw.n], deflateTail[:4]) {
+		return errors.New("deflate stream did not end in an empty block")
+	}
+	return nil
+}
+
+// trimWriter passes writes on to w, always holding back the last four
+// bytes.
+type trimWriter struct {
+	w    io.Writer
+	tail [4]byte
+	n    int
+}
+
+func (t *trimWriter) Write(p []byte) (int, error) {
+	buf := append(t.tail[:t.n:t.n], p...)
+	if len(buf) > len(t.tail) {
+		out := buf[:len(buf)-len(t.tail)]
+		if _, err := t.w.Write(out); err != nil {
+			return 0, err
+		}
+		buf = buf[len(out):]
+	}
+	t.n = copy(t.tail[:], buf)
+	return len(p), nil
+}
+
+// compress returns p as the payload of a compressed message.
+func compress(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	d := newDeflateWriter(&buf)
+	if _, err := d.Write(p); err != nil {
+		d.Close()
+		return nil, err
+	}
+	if err := d.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress inflates the payload of a compressed message, failing with
+// ErrMessageTooLarge past limit bytes if limit is positive.
+func decompress(p []byte, limit int64) ([]byte, error) {
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateTail)))
+	defer fr.Close()
+
+	var r io.Reader = fr
+	if limit > 0 {
+		r = io.LimitReader(fr, limit+1)
+	}
+	msg, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad compressed message: %v", ErrProtocol, err)
+	}
+	if limit > 0 && int64(len(msg)) > limit {
+		return nil, fmt.Errorf("%w: over %d bytes", ErrMessageTooLarge, limit)
+	}
+	return msg, nil
+}
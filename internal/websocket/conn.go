@@ -0,0 +1,395 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"unicode/utf8"
+)
+
+// DefaultReadLimit is the size of the largest message a Conn reads, after
+// decompression, unless SetReadLimit says otherwise.
+const DefaultReadLimit = 1 << 20
+
+// minCompressSize is the size under which messages are sent uncompressed
+// even with permessage-deflate, as compressing them saves next to nothing.
+const minCompressSize = 128
+
+// Conn is a WebSocket connection. One goroutine may read messages while
+// another writes them. Pings and close frames from the peer are answered
+// while reading, so a connection that is written to must be read from too.
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	isServer    bool
+	subprotocol string
+	// compress is set once permessage-deflate was agreed on
+	compress bool
+
+	readLimit int64
+	// readErr is sticky, the connection is gone once reading failed
+	readErr error
+
+	wmu       sync.Mutex
+	writeErr  error
+	closeSent bool
+}
+
+// newConn returns a Conn over conn, reading the bytes in buffered before
+// the connection. Only the server side is exported, the client side is for
+// tests.
+func newConn(conn net.Conn, buffered []byte, isServer bool) *Conn {
+	var r io.Reader = conn
+	if len(buffered) > 0 {
+		r = io.MultiReader(bytes.NewReader(buffered), conn)
+	}
+	return &Conn{
+		conn:      conn,
+		br:        bufio.NewReader(r),
+		isServer:  isServer,
+		readLimit: DefaultReadLimit,
+	}
+}
+
+// Subprotocol returns the subprotocol agreed on in the handshake, or "".
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// NetConn returns the underlying connection, e.g. to set deadlines.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// SetReadLimit sets the size of the largest message ReadMessage accepts. A
+// larger one fails the connection with CloseMessageTooBig. A limit of 0 or
+// less restores DefaultReadLimit.
+func (c *Conn) SetReadLimit(limit int64) {
+	if limit <= 0 {
+		limit = DefaultReadLimit
+	}
+	c.readLimit = limit
+}
+
+// ReadMessage reads the next data message, put together from its
+// fragments. Control frames in between are dealt with: a ping is answered
+// with a pong and a close frame is answered in kind, after which
+// ReadMessage returns a *CloseError and the connection is closed.
+//
+// A peer breaking the protocol, sending invalid UTF-8 as text or a message
+// over the read limit fails the connection with the matching close code.
+// Errors are final: every later call returns the same one.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	typ, msg, err := c.readMessage()
+	if err != nil {
+		c.readErr = err
+		var closeErr *CloseError
+		if !errors.As(err, &closeErr) {
+			c.fail(err)
+		}
+		return 0, nil, err
+	}
+	return typ, msg, nil
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var (
+		msg        []byte
+		op         opcode
+		started    bool
+		compressed bool
+	)
+	for {
+		h, err := readFrameHeader(c.br)
+		if err != nil {
+			return 0, nil, err
+		}
+		if h.masked != c.isServer {
+			return 0, nil, fmt.Errorf("%w: frames from the client must be masked, from the server not", ErrProtocol)
+		}
+		if h.rsv1 && (!c.compress || h.op.isControl() || h.op == opContinuation) {
+			return 0, nil, fmt.Errorf("%w: reserved bit set", ErrProtocol)
+		}
+
+		if h.op.isControl() {
+			payload, err := c.readPayload(h, nil)
+			if err != nil {
+				return 0, nil, err
+			}
+			if err := c.handleControl(h.op, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		if h.op == opContinuation {
+			if !started {
+				return 0, nil, fmt.Errorf("%w: continuation frame without a message", ErrProtocol)
+			}
+		} else {
+			if started {
+				return 0, nil, fmt.Errorf("%w: new message before the last one ended", ErrProtocol)
+			}
+			started, op, compressed = true, h.op, h.rsv1
+		}
+
+		if int64(len(msg))+h.length > c.readLimit {
+			return 0, nil, fmt.Errorf("%w: over %d bytes", ErrMessageTooLarge, c.readLimit)
+		}
+		if msg, err = c.readPayload(h, msg); err != nil {
+			return 0, nil, err
+		}
+		if h.fin {
+			break
+		}
+	}
+
+	if compressed {
+		var err error
+		if msg, err = decompress(msg, c.readLimit); err != nil {
+			return 0, nil, err
+		}
+	}
+	if op == opText && !utf8.Valid(msg) {
+		return 0, nil, ErrInvalidUTF8
+	}
+	return MessageType(op), msg, nil
+}
+
+// readPayload appends the unmasked payload of the frame to dst.
+func (c *Conn) readPayload(h frameHeader, dst []byte) ([]byte, error) {
+	start := len(dst)
+	dst = slices.Grow(dst, int(h.length))[:start+int(h.length)]
+	if _, err := io.ReadFull(c.br, dst[start:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if h.masked {
+		maskBytes(h.mask, 0, dst[start:])
+	}
+	return dst, nil
+}
+
+func (c *Conn) handleControl(op opcode, payload []byte) error {
+	switch op {
+	case opPing:
+		err := c.writeFrame(opPong, true, false, payload)
+		if err != nil && !errors.Is(err, ErrClosed) {
+			return err
+		}
+	case opClose:
+		closeErr, err := parseClose(payload)
+		if err != nil {
+			return err
+		}
+		// answer with the same status; if the close frame was ours to begin
+		// with, this one is the answer and nothing is sent
+		reply := payload[:min(len(payload), 2)]
+		c.writeFrame(opClose, true, false, reply)
+		c.conn.Close()
+		return closeErr
+	}
+	return nil
+}
+
+func parseClose(payload []byte) (*CloseError, error) {
+	switch {
+	case len(payload) == 0:
+		return &CloseError{Code: CloseNoStatus}, nil
+	case len(payload) == 1:
+		return nil, fmt.Errorf("%w: truncated close status", ErrProtocol)
+	}
+	code := CloseCode(binary.BigEndian.Uint16(payload))
+	if !validCloseCode(code) {
+		return nil, fmt.Errorf("%w: invalid close status %d", ErrProtocol, code)
+	}
+	reason := payload[2:]
+	if !utf8.Valid(reason) {
+		return nil, fmt.Errorf("%w: close reason", ErrInvalidUTF8)
+	}
+	return &CloseError{Code: code, Reason: string(reason)}, nil
+}
+
+// fail closes the connection after a read error, telling the peer why if
+// it broke the protocol.
+func (c *Conn) fail(err error) {
+	code := closeCodeFor(err)
+	if code != CloseInternalError {
+		c.WriteClose(code, "")
+	}
+	c.conn.Close()
+}
+
+// WriteMessage sends data as a single frame, compressed if
+// permessage-deflate was agreed on. Text must be valid UTF-8.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("websocket: unknown message type %d", typ)
+	}
+	if typ == TextMessage && !utf8.Valid(data) {
+		return ErrInvalidUTF8
+	}
+
+	compressed := c.compress && len(data) >= minCompressSize
+	if compressed {
+		var err error
+		if data, err = compress(data); err != nil {
+			return err
+		}
+	}
+	return c.writeFrame(opcode(typ), true, compressed, data)
+}
+
+// NextWriter returns a writer for a message sent in fragments, one frame
+// per Write, ended by Close. It suits messages too large to hold in memory
+// or produced bit by bit. The writer must be closed before the next
+// message is written. Text written this way is not checked for valid
+// UTF-8.
+func (c *Conn) NextWriter(typ MessageType) (io.WriteCloser, error) {
+	if typ != TextMessage && typ != BinaryMessage {
+		return nil, fmt.Errorf("websocket: unknown message type %d", typ)
+	}
+	w := &messageWriter{c: c, op: opcode(typ)}
+	if c.compress {
+		w.rsv1 = true
+		w.deflate = newDeflateWriter(fragments{w})
+	}
+	return w, nil
+}
+
+// messageWriter sends a message in fragments, see NextWriter.
+type messageWriter struct {
+	c *Conn
+	// op and rsv1 are those of the next frame: the message type and
+	// compression go on the first one only
+	op      opcode
+	rsv1    bool
+	deflate *deflateWriter
+	closed  bool
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if w.deflate != nil {
+		return w.deflate.Write(p)
+	}
+	if err := w.frame(p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.deflate != nil {
+		if err := w.deflate.Close(); err != nil {
+			return err
+		}
+	}
+	return w.frame(nil, true)
+}
+
+func (w *messageWriter) frame(p []byte, fin bool) error {
+	if len(p) == 0 && !fin {
+		return nil
+	}
+	err := w.c.writeFrame(w.op, fin, w.rsv1, p)
+	w.op, w.rsv1 = opContinuation, false
+	return err
+}
+
+// fragments sends what the compressor writes as frames of the message.
+type fragments struct {
+	w *messageWriter
+}
+
+func (f fragments) Write(p []byte) (int, error) {
+	if err := f.w.frame(p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Ping sends a ping, which the peer answers with a pong carrying the same
+// data.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("websocket: ping data over %d bytes", maxControlPayload)
+	}
+	return c.writeFrame(opPing, true, false, data)
+}
+
+// WriteClose starts the close handshake. Nothing can be written after it;
+// ReadMessage returns a *CloseError once the peer answers, and the
+// connection is closed then.
+func (c *Conn) WriteClose(code CloseCode, reason string) error {
+	if !validCloseCode(code) {
+		return fmt.Errorf("websocket: invalid close status %d", code)
+	}
+	if len(reason)+2 > maxControlPayload {
+		return fmt.Errorf("websocket: close reason over %d bytes", maxControlPayload-2)
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	return c.writeFrame(opClose, true, false, payload)
+}
+
+// Close closes the connection without waiting for the close handshake,
+// sending a close frame first if none was sent.
+func (c *Conn) Close() error {
+	c.WriteClose(CloseNormal, "")
+	return c.conn.Close()
+}
+
+// writeFrame sends one frame, masked if this is the client side.
+func (c *Conn) writeFrame(op opcode, fin, rsv1 bool, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	h := frameHeader{
+		fin:    fin,
+		rsv1:   rsv1,
+		op:     op,
+		masked: !c.isServer,
+		length: int64(len(payload)),
+	}
+	if h.masked {
+		rand.Read(h.mask[:])
+	}
+	buf := appendFrameHeader(make([]byte, 0, 14+len(payload)), h)
+	start := len(buf)
+	buf = append(buf, payload...)
+	if h.masked {
+		maskBytes(h.mask, 0, buf[start:])
+	}
+
+	if _, err := c.conn.Write(buf); err != nil {
+		c.writeErr = err
+		return err
+	}
+	if op == opClose {
+		c.closeSent = true
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type opcode byte
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xA
+)
+
+// isControl reports whether op is a close, ping or pong, which may come
+// between the fragments of a message.
+func (op opcode) isControl() bool {
+	return op&0x8 != 0
+}
+
+func (op opcode) known() bool {
+	switch op {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+		return true
+	}
+	return false
+}
+
+// maxControlPayload is the longest payload of a control frame.
+const maxControlPayload = 125
+
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	rsv2Bit = 0x20
+	rsv3Bit = 0x10
+	maskBit = 0x80
+)
+
+// frameHeader is the part of a frame before its payload, see RFC 6455
+// section 5.2.
+type frameHeader struct {
+	fin bool
+	// rsv1 marks the first frame of a compressed message
+	rsv1   bool
+	op     opcode
+	masked bool
+	mask   [4]byte
+	length int64
+}
+
+func readFrameHeader(r io.Reader) (frameHeader, error) {
+	var h frameHeader
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return h, err
+	}
+
+	h.fin = buf[0]&finBit != 0
+	h.rsv1 = buf[0]&rsv1Bit != 0
+	h.op = opcode(buf[0] & 0x0f)
+	h.masked = buf[1]&maskBit != 0
+	h.length = int64(buf[1] &^ maskBit)
+
+	if buf[0]&(rsv2Bit|rsv3Bit) != 0 {
+		return h, fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	}
+	if !h.op.known() {
+		return h, fmt.Errorf("%w: unknown opcode %#x", ErrProtocol, byte(h.op))
+	}
+
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(r, buf[:2]); err != nil {
+			return h, unexpectedEOF(err)
+		}
+		h.length = int64(binary.BigEndian.Uint16(buf[:2]))
+	case 127:
+		if _, err := io.ReadFull(r, buf[:8]); err != nil {
+			return h, unexpectedEOF(err)
+		}
+		length := binary.BigEndian.Uint64(buf[:8])
+		if length>>63 != 0 {
+			return h, fmt.Errorf("%w: frame length out of range", ErrProtocol)
+		}
+		h.length = int64(length)
+	}
+
+	if h.op.isControl() && (!h.fin || h.length > maxControlPayload) {
+		return h, fmt.Errorf("%w: control frames must be whole and at most %d bytes",
+			ErrProtocol, maxControlPayload)
+	}
+
+	if h.masked {
+		if _, err := io.ReadFull(r, h.mask[:]); err != nil {
+			return h, unexpectedEOF(err)
+		}
+	}
+	return h, nil
+}
+
+// appendFrameHeader appends the wire form of h to b, using the shortest
+// length encoding as required.
+func appendFrameHeader(b []byte, h frameHeader) []byte {
+	b0 := byte(h.op)
+	if h.fin {
+		b0 |= finBit
+	}
+	if h.rsv1 {
+		b0 |= rsv1Bit
+	}
+	var b1 byte
+	if h.masked {
+		b1 = maskBit
+	}
+
+	switch {
+	case h.length <= 125:
+		b = append(b, b0, b1|byte(h.length))
+	case h.length <= 0xffff:
+		b = append(b, b0, b1|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(h.length))
+	default:
+		b = append(b, b0, b1|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(h.length))
+	}
+
+	if h.masked {
+		b = append(b, h.mask[:]...)
+	}
+	return b
+}
+
+// maskBytes XORs b with mask, starting pos bytes into the payload. It
+// returns the position after b, so a payload can be masked in pieces.
+func maskBytes(mask [4]byte, pos int, b []byte) int {
+	for i := range b {
+		b[i] ^= mask[(pos+i)&3]
+	}
+	return (pos + len(b)) & 3
+}
+
+// unexpectedEOF turns the end of the connection in the middle of a frame
+// into an error.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
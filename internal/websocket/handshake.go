@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"tcpTohttp/internal/headers"
+	"tcpTohttp/internal/request"
+	"tcpTohttp/internal/response"
+)
+
+// acceptGUID is mixed into Sec-WebSocket-Accept, see RFC 6455 section 1.3.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Upgrader turns requests into WebSocket connections.
+type Upgrader struct {
+	// Subprotocols the server speaks, most preferred first. The first one
+	// the client asks for too is used.
+	Subprotocols []string
+	// CheckOrigin decides whether a browser may connect from the page it
+	// is on. Without it, a request with an Origin must come from the host
+	// it is sent to.
+	CheckOrigin func(req *request.Request) bool
+	// EnableCompression agrees to permessage-deflate if the client offers
+	// it.
+	EnableCompression bool
+	// ReadLimit is passed to SetReadLimit on the new connection.
+	ReadLimit int64
+}
+
+// Upgrade completes the opening handshake: it checks the request, sends
+// 101 Switching Protocols and takes over the connection. A request that
+// is not a valid handshake is answered with an error and ErrBadHandshake is
+// returned. The handler owns the Conn returned and must close it.
+func (u *Upgrader) Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	status, err := u.check(req)
+	if err != nil {
+		if status == response.StatusUpgradeRequired {
+			w.Header().Set("Upgrade", "websocket")
+			w.Header().Set("Sec-WebSocket-Version", "13")
+		}
+		w.Error(status, err.Error())
+		return nil, err
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", "websocket")
+	h.Set("Sec-WebSocket-Accept", acceptKey(req.Headers.Get("Sec-WebSocket-Key")))
+	subprotocol := u.subprotocol(req)
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	compress := u.EnableCompression && acceptDeflate(req.Headers.Get("Sec-WebSocket-Extensions"))
+	if compress {
+		h.Set("Sec-WebSocket-Extensions", deflateResponse)
+	}
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h, []string{"Content-Type"}, nil); err != nil {
+		return nil, err
+	}
+
+	netConn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(netConn, buffered, true)
+	c.subprotocol = subprotocol
+	c.compress = compress
+	c.SetReadLimit(u.ReadLimit)
+	return c, nil
+}
+
+// check validates the handshake, returning the status to refuse it with.
+func (u *Upgrader) check(req *request.Request) (response.StatusCode, error) {
+	if req.RequestLine.Method != "GET" {
+		return response.StatusMethodNotAllowed, fmt.Errorf("%w: method must be GET", ErrBadHandshake)
+	}
+	if req.RequestLine.HttpVersion != "1.1" {
+		return response.StatusBadRequest, fmt.Errorf("%w: HTTP/1.1 required", ErrBadHandshake)
+	}
+	if !hasToken(req.Headers.Get("Connection"), "upgrade") ||
+		!hasToken(req.Headers.Get("Upgrade"), "websocket") {
+		return response.StatusUpgradeRequired, fmt.Errorf("%w: not a websocket upgrade", ErrBadHandshake)
+	}
+	if req.Headers.Get("Sec-WebSocket-Version") != "13" {
+		return response.StatusUpgradeRequired, fmt.Errorf("%w: unsupported version", ErrBadHandshake)
+	}
+	key, err := base64.StdEncoding.DecodeString(req.Headers.Get("Sec-WebSocket-Key"))
+	if err != nil || len(key) != 16 {
+		return response.StatusBadRequest, fmt.Errorf("%w: bad Sec-WebSocket-Key", ErrBadHandshake)
+	}
+
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return response.StatusForbidden, fmt.Errorf("%w: origin not allowed", ErrBadHandshake)
+	}
+	return response.StatusSwitchingProtocols, nil
+}
+
+func (u *Upgrader) subprotocol(req *request.Request) string {
+	offered := req.Headers.Get("Sec-WebSocket-Protocol")
+	for _, protocol := range u.Subprotocols {
+		if hasToken(offered, protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
+
+// sameOrigin lets through clients sending no Origin, which are not
+// browsers, and browsers on a page of the host the request is sent to.
+func sameOrigin(req *request.Request) bool {
+	origin := req.Headers.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Headers.Get("Host"))
+}
+
+// acceptKey returns the Sec-WebSocket-Accept answering key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// hasToken reports whether a comma separated header value lists token.
+func hasToken(value, token string) bool {
+	for item := range strings.SplitSeq(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), token) {
+			return true
+		}
+	}
+	return false
+}
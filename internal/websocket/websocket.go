@@ -0,0 +1,88 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrBadHandshake    = errors.New("bad websocket handshake")
+	ErrProtocol        = errors.New("websocket protocol error")
+	ErrMessageTooLarge = errors.New("websocket message too large")
+	ErrInvalidUTF8     = errors.New("invalid utf-8 in text message")
+	ErrClosed          = errors.New("websocket connection closed")
+)
+
+// MessageType is the kind of a data message, matching its opcode.
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// CloseCode is the status sent in a close frame, see RFC 6455 section 7.4.
+type CloseCode int
+
+const (
+	CloseNormal          CloseCode = 1000
+	CloseGoingAway       CloseCode = 1001
+	CloseProtocolError   CloseCode = 1002
+	CloseUnsupportedData CloseCode = 1003
+	// CloseNoStatus stands for a close frame without a status. It is never
+	// sent.
+	CloseNoStatus CloseCode = 1005
+	// CloseAbnormal stands for a connection lost without a close frame. It
+	// is never sent.
+	CloseAbnormal           CloseCode = 1006
+	CloseInvalidPayload     CloseCode = 1007
+	ClosePolicyViolation    CloseCode = 1008
+	CloseMessageTooBig      CloseCode = 1009
+	CloseMandatoryExtension CloseCode = 1010
+	CloseInternalError      CloseCode = 1011
+	CloseServiceRestart     CloseCode = 1012
+	CloseTryAgainLater      CloseCode = 1013
+	CloseBadGateway         CloseCode = 1014
+)
+
+// validCloseCode reports whether code may be sent in a close frame: the
+// codes defined for the protocol and those left to applications.
+func validCloseCode(code CloseCode) bool {
+	switch {
+	case code >= CloseNormal && code <= CloseUnsupportedData:
+		return true
+	case code >= CloseInvalidPayload && code <= CloseBadGateway:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// CloseError is returned by ReadMessage once the peer closed the
+// connection, with the status and reason it gave.
+type CloseError struct {
+	Code   CloseCode
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed: %d", e.Code)
+	}
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// closeCodeFor returns the status a connection failing with err is closed
+// with.
+func closeCodeFor(err error) CloseCode {
+	switch {
+	case errors.Is(err, ErrProtocol):
+		return CloseProtocolError
+	case errors.Is(err, ErrInvalidUTF8):
+		return CloseInvalidPayload
+	case errors.Is(err, ErrMessageTooLarge):
+		return CloseMessageTooBig
+	}
+	return CloseInternalError
+}
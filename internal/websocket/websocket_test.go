@@ -0,0 +1,330 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcpTohttp/internal/request"
+	"tcpTohttp/internal/response"
+)
+
+const handshake = "GET /chat HTTP/1.1\r\n" +
+	"Host: example.com\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: keep-alive, Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n"
+
+// upgrade runs the handshake in raw over a pipe, returning the server Conn,
+// the client end and the response head the client got.
+func upgrade(t *testing.T, u *Upgrader, raw string) (*Conn, *Conn, string) {
+	t.Helper()
+	serverEnd, clientEnd := net.Pipe()
+	t.Cleanup(func() {
+		serverEnd.Close()
+		clientEnd.Close()
+	})
+
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	w := response.NewWriter(serverEnd, true)
+	w.SetHijacker(func() (net.Conn, []byte, error) {
+		return serverEnd, nil, nil
+	})
+
+	done := make(chan *Conn)
+	go func() {
+		c, _ := u.Upgrade(w, req)
+		w.Finish()
+		done <- c
+	}()
+
+	br := bufio.NewReader(clientEnd)
+	var head strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		head.WriteString(line)
+		if line == "\r\n" {
+			break
+		}
+	}
+	if !strings.HasPrefix(head.String(), "HTTP/1.1 101 ") {
+		return <-done, nil, head.String()
+	}
+	client := newConn(clientEnd, nil, false)
+	client.br = br
+	return <-done, client, head.String()
+}
+
+// writeRaw sends a frame from the client side as is.
+func writeRaw(t *testing.T, c *Conn, h frameHeader, payload []byte) {
+	t.Helper()
+	go func() {
+		buf := appendFrameHeader(nil, h)
+		start := len(buf)
+		buf = append(buf, payload...)
+		if h.masked {
+			maskBytes(h.mask, 0, buf[start:])
+		}
+		c.conn.Write(buf)
+	}()
+}
+
+func TestAcceptKey(t *testing.T) {
+	// the example of RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestUpgrade(t *testing.T) {
+	u := &Upgrader{Subprotocols: []string{"v2.dashboard", "v1.dashboard"}}
+	server, client, head := upgrade(t, u, handshake+
+		"Sec-WebSocket-Protocol: v1.dashboard, v2.dashboard\r\n\r\n")
+	require.NotNil(t, server)
+	require.NotNil(t, client)
+	assert.Contains(t, head, "Upgrade: websocket\r\n")
+	assert.Contains(t, head, "Connection: Upgrade\r\n")
+	assert.Contains(t, head, "Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.Contains(t, head, "Sec-WebSocket-Protocol: v2.dashboard\r\n")
+	assert.NotContains(t, head, "Content-Type")
+	assert.NotContains(t, head, "Sec-WebSocket-Extensions")
+	assert.Equal(t, "v2.dashboard", server.Subprotocol())
+
+	go client.WriteMessage(TextMessage, []byte("hello"))
+	typ, msg, err := server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, typ)
+	assert.Equal(t, "hello", string(msg))
+
+	go server.WriteMessage(BinaryMessage, []byte{1, 2, 3})
+	typ, msg, err = client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, typ)
+	assert.Equal(t, []byte{1, 2, 3}, msg)
+}
+
+func TestBadHandshake(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		status string
+	}{
+		{"not GET", strings.Replace(handshake, "GET", "POST", 1) + "\r\n", "405"},
+		{"no upgrade", strings.Replace(handshake, "Upgrade: websocket\r\n", "", 1) + "\r\n", "426"},
+		{"version", strings.Replace(handshake, "Version: 13", "Version: 8", 1) + "\r\n", "426"},
+		{"key", strings.Replace(handshake, "dGhlIHNhbXBsZSBub25jZQ==", "c2hvcnQ=", 1) + "\r\n", "400"},
+		{"origin", handshake + "Origin: https://evil.example\r\n\r\n", "403"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _, head := upgrade(t, &Upgrader{}, tt.raw)
+			assert.Nil(t, server)
+			assert.True(t, strings.HasPrefix(head, "HTTP/1.1 "+tt.status+" "), head)
+			if tt.status == "426" {
+				assert.Contains(t, head, "Sec-WebSocket-Version: 13\r\n")
+			}
+		})
+	}
+
+	server, _, _ := upgrade(t, &Upgrader{}, handshake+"Origin: http://example.com\r\n\r\n")
+	assert.NotNil(t, server)
+}
+
+func TestFragmentsAndControlFrames(t *testing.T) {
+	server, client, _ := upgrade(t, &Upgrader{}, handshake+"\r\n")
+	mask := [4]byte{1, 2, 3, 4}
+
+	go func() {
+		writeFrames := []struct {
+			h       frameHeader
+			payload string
+		}{
+			{frameHeader{op: opText, masked: true, mask: mask}, "hel"},
+			{frameHeader{fin: true, op: opPing, masked: true, mask: mask}, "are you there"},
+			{frameHeader{fin: true, op: opContinuation, masked: true, mask: mask}, "lo"},
+		}
+		for _, f := range writeFrames {
+			f.h.length = int64(len(f.payload))
+			buf := appendFrameHeader(nil, f.h)
+			start := len(buf)
+			buf = append(buf, f.payload...)
+			maskBytes(f.h.mask, 0, buf[start:])
+			client.conn.Write(buf)
+		}
+	}()
+
+	pong := make(chan []byte)
+	go func() {
+		h, _ := readFrameHeader(client.br)
+		payload, _ := client.readPayload(h, nil)
+		assert.Equal(t, opPong, h.op)
+		pong <- payload
+	}()
+
+	_, msg, err := server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(msg))
+	assert.Equal(t, "are you there", string(<-pong))
+
+	// NextWriter sends a frame per write
+	go func() {
+		w, _ := server.NextWriter(BinaryMessage)
+		w.Write([]byte("ab"))
+		w.Write([]byte("cd"))
+		w.Close()
+	}()
+	var frames []string
+	for {
+		h, err := readFrameHeader(client.br)
+		require.NoError(t, err)
+		payload, err := client.readPayload(h, nil)
+		require.NoError(t, err)
+		if len(frames) == 0 {
+			assert.Equal(t, opBinary, h.op)
+		} else {
+			assert.Equal(t, opContinuation, h.op)
+		}
+		frames = append(frames, string(payload))
+		if h.fin {
+			break
+		}
+	}
+	assert.Equal(t, []string{"ab", "cd", ""}, frames)
+}
+
+func TestProtocolErrors(t *testing.T) {
+	mask := [4]byte{9, 8, 7, 6}
+	tests := []struct {
+		name    string
+		h       frameHeader
+		payload string
+		limit   int64
+		err     error
+		code    CloseCode
+	}{
+		{"unmasked", frameHeader{fin: true, op: opText}, "hi", 0, ErrProtocol, CloseProtocolError},
+		{"reserved bit", frameHeader{fin: true, rsv1: true, op: opText, masked: true, mask: mask}, "hi", 0, ErrProtocol, CloseProtocolError},
+		{"continuation first", frameHeader{fin: true, op: opContinuation, masked: true, mask: mask}, "hi", 0, ErrProtocol, CloseProtocolError},
+		{"fragmented ping", frameHeader{op: opPing, masked: true, mask: mask}, "hi", 0, ErrProtocol, CloseProtocolError},
+		{"utf-8", frameHeader{fin: true, op: opText, masked: true, mask: mask}, "\xff\xfe", 0, ErrInvalidUTF8, CloseInvalidPayload},
+		{"too large", frameHeader{fin: true, op: opBinary, masked: true, mask: mask}, "0123456789", 4, ErrMessageTooLarge, CloseMessageTooBig},
+		{"close status", frameHeader{fin: true, op: opClose, masked: true, mask: mask}, "\x03\xed", 0, ErrProtocol, CloseProtocolError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client, _ := upgrade(t, &Upgrader{ReadLimit: tt.limit}, handshake+"\r\n")
+			tt.h.length = int64(len(tt.payload))
+			writeRaw(t, client, tt.h, []byte(tt.payload))
+
+			closed := make(chan error)
+			go func() {
+				_, _, err := client.ReadMessage()
+				closed <- err
+			}()
+			_, _, err := server.ReadMessage()
+			assert.ErrorIs(t, err, tt.err)
+			var closeErr *CloseError
+			require.ErrorAs(t, <-closed, &closeErr)
+			assert.Equal(t, tt.code, closeErr.Code)
+
+			_, _, again := server.ReadMessage()
+			assert.Equal(t, err, again)
+		})
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	server, client, _ := upgrade(t, &Upgrader{}, handshake+"\r\n")
+
+	go client.WriteClose(CloseGoingAway, "bye")
+	answer := make(chan error)
+	go func() {
+		_, _, err := client.ReadMessage()
+		answer <- err
+	}()
+	_, _, err := server.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, &CloseError{Code: CloseGoingAway, Reason: "bye"}, closeErr)
+	assert.ErrorIs(t, server.WriteMessage(TextMessage, []byte("late")), ErrClosed)
+
+	// the client gets the status back
+	require.ErrorAs(t, <-answer, &closeErr)
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+	assert.ErrorIs(t, client.WriteClose(CloseNormal, ""), ErrClosed)
+
+	assert.Error(t, server.WriteClose(CloseNoStatus, ""))
+}
+
+func TestCompression(t *testing.T) {
+	u := &Upgrader{EnableCompression: true}
+	server, client, head := upgrade(t, u, handshake+
+		"Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n\r\n")
+	assert.Contains(t, head, "Sec-WebSocket-Extensions: "+deflateResponse+"\r\n")
+	client.compress = true
+
+	text := strings.Repeat("live update ", 100)
+	go client.WriteMessage(TextMessage, []byte(text))
+	_, msg, err := server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, text, string(msg))
+
+	// the first fragment is marked compressed, the rest follow as is
+	go func() {
+		w, _ := server.NextWriter(TextMessage)
+		w.Write([]byte(text))
+		w.Write([]byte(text))
+		w.Close()
+	}()
+	_, msg, err = client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, text+text, string(msg))
+
+	small := bytes.Repeat([]byte{7}, minCompressSize-1)
+	go server.WriteMessage(BinaryMessage, small)
+	h, err := readFrameHeader(client.br)
+	require.NoError(t, err)
+	assert.False(t, h.rsv1)
+	assert.Equal(t, int64(len(small)), h.length)
+}
+
+func TestAcceptDeflate(t *testing.T) {
+	assert.True(t, acceptDeflate("permessage-deflate"))
+	assert.True(t, acceptDeflate("permessage-deflate; server_max_window_bits=15"))
+	assert.True(t, acceptDeflate("x-webkit-deflate-frame, permessage-deflate; client_no_context_takeover"))
+	assert.False(t, acceptDeflate("permessage-deflate; server_max_window_bits=10"))
+	assert.False(t, acceptDeflate("permessage-deflate; unknown"))
+	assert.False(t, acceptDeflate(""))
+
+	// a compressed message is rejected by a reader not told about it
+	server, client, _ := upgrade(t, &Upgrader{}, handshake+
+		"Sec-WebSocket-Extensions: permessage-deflate\r\n\r\n")
+	client.compress = true
+	go client.WriteMessage(BinaryMessage, bytes.Repeat([]byte{1}, minCompressSize))
+	go client.ReadMessage()
+	_, _, err := server.ReadMessage()
+	assert.ErrorIs(t, err, ErrProtocol)
+}
+
+func TestFrameHeader(t *testing.T) {
+	for _, length := range []int64{0, 125, 126, 0xffff, 0x10000} {
+		h := frameHeader{fin: true, op: opBinary, masked: true, mask: [4]byte{1, 2, 3, 4}, length: length}
+		got, err := readFrameHeader(bytes.NewReader(appendFrameHeader(nil, h)))
+		require.NoError(t, err)
+		assert.Equal(t, h, got)
+	}
+
+	_, err := readFrameHeader(bytes.NewReader([]byte{0x83, 0x00}))
+	assert.ErrorIs(t, err, ErrProtocol)
+	_, err = readFrameHeader(bytes.NewReader([]byte{0xa1, 0x00}))
+	assert.ErrorIs(t, err, ErrProtocol)
+	huge := binary.BigEndian.AppendUint64([]byte{0x82, 127}, 1<<63)
+	_, err = readFrameHeader(bytes.NewReader(huge))
+	assert.ErrorIs(t, err, ErrProtocol)
+}